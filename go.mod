@@ -2,6 +2,7 @@ module github.com/holoplot/go-multicast
 
 go 1.25.0
 
-require golang.org/x/net v0.56.0
-
-require golang.org/x/sys v0.46.0 // indirect
+require (
+	golang.org/x/net v0.56.0
+	golang.org/x/sys v0.46.0
+)
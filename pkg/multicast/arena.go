@@ -0,0 +1,110 @@
+package multicast
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// slab is a large buffer payloads are carved from. It goes back to its pool
+// once the arena has moved on to another slab and every packet holding a
+// part of it has been released.
+type slab struct {
+	buf  []byte
+	off  int
+	refs atomic.Int32
+	pool *sync.Pool
+}
+
+func (s *slab) release() {
+	if s.refs.Add(-1) == 0 {
+		s.off = 0
+		s.pool.Put(s)
+	}
+}
+
+// arena hands out payload buffers from slabs. Each read loop has its own
+// arena, while the slabs are recycled through a pool shared by the
+// consumer.
+type arena struct {
+	size    int
+	pool    *sync.Pool
+	current *slab
+}
+
+func newSlabPool(size int) *sync.Pool {
+	pool := &sync.Pool{}
+
+	pool.New = func() any {
+		return &slab{buf: make([]byte, size), pool: pool}
+	}
+
+	return pool
+}
+
+// copy returns a copy of b along with the slab it was carved from, which
+// the caller has to release. Buffers larger than a slab are allocated
+// separately and returned without a slab.
+func (a *arena) copy(b []byte) ([]byte, *slab) {
+	if len(b) > a.size {
+		return bytes.Clone(b), nil
+	}
+
+	s := a.current
+
+	if s == nil || len(s.buf)-s.off < len(b) {
+		if s != nil {
+			s.release()
+		}
+
+		s = a.pool.Get().(*slab)
+		s.refs.Store(1)
+		a.current = s
+	}
+
+	out := s.buf[s.off : s.off+len(b) : s.off+len(b)]
+	copy(out, b)
+
+	s.off += len(b)
+	s.refs.Add(1)
+
+	return out, s
+}
+
+// close releases the arena's hold on its current slab.
+func (a *arena) close() {
+	if a.current != nil {
+		a.current.release()
+		a.current = nil
+	}
+}
+
+// Retain lets the packet's payload outlive the callback. A payload carved
+// from an arena, see WithPayloadArena, is copied out of it, while other
+// payloads are already owned by the packet and left as they are.
+func (p *Packet) Retain() {
+	if p.slab == nil {
+		return
+	}
+
+	p.Payload = bytes.Clone(p.Payload)
+
+	p.slab.release()
+	p.slab = nil
+}
+
+// releasePayload hands a payload carved from an arena back to it.
+func (p *Packet) releasePayload() {
+	if p.slab != nil {
+		p.slab.release()
+		p.slab = nil
+	}
+}
+
+// releaseAfter wraps cb to release arena payloads once it returns.
+func releaseAfter(cb PacketCallback) PacketCallback {
+	return func(pkt *Packet) {
+		cb(pkt)
+		pkt.releasePayload()
+	}
+}
@@ -0,0 +1,109 @@
+package multicast
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// BatchCallback receives the packets of one interface in batches, in the
+// order they were delivered.
+type BatchCallback func(ifi *net.Interface, packets []Packet)
+
+// batcher collects packets per interface and hands them to the callback
+// once a batch is full or its oldest packet has waited for maxLatency.
+type batcher struct {
+	cb         BatchCallback
+	size       int
+	maxLatency time.Duration
+	clock      Clock
+
+	// Held while a batch is delivered, so batches never overlap
+	mutex   sync.Mutex
+	pending map[int]*batch
+}
+
+type batch struct {
+	ifi     *net.Interface
+	packets []Packet
+	timer   Timer
+}
+
+// add is the packet callback feeding the batches.
+func (b *batcher) add(pkt *Packet) {
+	// The batch outlives the invocation the packet was delivered to
+	pkt.Retain()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	index := pkt.Interface.Index
+
+	current, ok := b.pending[index]
+	if !ok {
+		current = &batch{ifi: pkt.Interface, packets: make([]Packet, 0, b.size)}
+		b.pending[index] = current
+
+		current.timer = b.clock.AfterFunc(b.maxLatency, func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			if b.pending[index] == current {
+				b.flush(index, current)
+			}
+		})
+	}
+
+	current.packets = append(current.packets, *pkt)
+
+	if len(current.packets) == b.size {
+		current.timer.Stop()
+		b.flush(index, current)
+	}
+}
+
+// flush delivers a pending batch. The mutex must be held.
+func (b *batcher) flush(index int, current *batch) {
+	delete(b.pending, index)
+	b.cb(current.ifi, current.packets)
+}
+
+// flushAll delivers all partial batches.
+func (b *batcher) flushAll() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for index, current := range b.pending {
+		current.timer.Stop()
+		b.flush(index, current)
+	}
+}
+
+// WithBatchCallback delivers packets to cb in batches of up to size packets
+// per interface instead of to the consumer's regular callback, which
+// amortizes per-call overhead such as a database insert. A partial batch is
+// delivered once its first packet has waited for maxLatency, and when the
+// consumer is closed. Batches are delivered one at a time, either from the
+// goroutine that completed them or from a timer, and Close must not be
+// called from cb.
+func WithBatchCallback(cb BatchCallback, size int, maxLatency time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if size < 1 {
+			return fmt.Errorf("invalid batch size %d: must be at least 1", size)
+		}
+
+		if maxLatency <= 0 {
+			return fmt.Errorf("invalid batch latency %s: must be positive", maxLatency)
+		}
+
+		o.batch = &batcher{
+			cb:         cb,
+			size:       size,
+			maxLatency: maxLatency,
+			pending:    make(map[int]*batch),
+		}
+
+		return nil
+	}
+}
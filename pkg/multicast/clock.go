@@ -0,0 +1,59 @@
+package multicast
+
+import (
+	"time"
+)
+
+// Clock is the source of time for a consumer's time-based features, such as
+// rejoining on silence, the pruning detection and the callback deadline.
+// It defaults to the real time and may be replaced with WithClock, mostly
+// to drive these features deterministically in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTicker returns a ticker sending the time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine once d has elapsed, unless the
+	// returned timer is stopped before.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker is the ticker returned by Clock.NewTicker.
+type Ticker interface {
+	// C returns the channel the ticks are sent on.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Timer is the timer returned by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer
+	// already fired or was stopped before.
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,75 @@
+package multicast
+
+import (
+	"fmt"
+)
+
+// CallbackConcurrency selects which goroutines invoke a consumer's callback,
+// and with that whether invocations may overlap.
+type CallbackConcurrency struct {
+	mode    concurrencyMode
+	workers int
+}
+
+type concurrencyMode int
+
+const (
+	concurrencyPerInterface concurrencyMode = iota
+	concurrencySerial
+	concurrencyWorkerPool
+)
+
+var (
+	// PerInterface invokes the callback from the read loop of every socket,
+	// one per interface, or several with WithFanout. Invocations for
+	// different interfaces may overlap, while those for one socket happen
+	// one at a time, in the order the packets were read. A slow callback
+	// holds up reading on its interface. This is the default.
+	PerInterface = CallbackConcurrency{}
+
+	// Serial invokes the callback from a single goroutine fed through a
+	// queue by all read loops, so invocations never overlap.
+	Serial = CallbackConcurrency{mode: concurrencySerial}
+)
+
+// WorkerPool invokes the callback from n goroutines fed through a queue by
+// all read loops. Up to n invocations may overlap, and packets may be
+// delivered out of order unless WithSourceOrdering is added.
+func WorkerPool(n int) CallbackConcurrency {
+	return CallbackConcurrency{mode: concurrencyWorkerPool, workers: n}
+}
+
+func (cc CallbackConcurrency) String() string {
+	switch cc.mode {
+	case concurrencySerial:
+		return "serial"
+	case concurrencyWorkerPool:
+		return fmt.Sprintf("worker pool of %d", cc.workers)
+	default:
+		return "per interface"
+	}
+}
+
+// WithCallbackConcurrency selects the goroutines the callback is invoked
+// from. WithSerialDelivery and WithWorkerPool are shorthands for Serial and
+// WorkerPool.
+func WithCallbackConcurrency(cc CallbackConcurrency) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.serial = false
+		o.workers = 0
+
+		switch cc.mode {
+		case concurrencySerial:
+			o.serial = true
+
+		case concurrencyWorkerPool:
+			if cc.workers < 1 {
+				return fmt.Errorf("invalid worker count %d: must be at least 1", cc.workers)
+			}
+
+			o.workers = cc.workers
+		}
+
+		return nil
+	}
+}
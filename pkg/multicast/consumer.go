@@ -1,80 +1,691 @@
 package multicast
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"runtime"
+	"runtime/pprof"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/ipv4"
 )
 
 const (
-	maxMTU = 1500
+	maxMTU  = 1500
+	oobSize = 256
+
+	// The largest UDP datagram over IPv4, including headers
+	maxDatagram = 65535
+)
+
+var (
+	ErrMembershipLimit = errors.New("multicast membership limit reached")
+	ErrStartTimeout    = errors.New("timed out starting consumer")
+
+	ErrBindRetriesExhausted = errors.New("address still in use after retrying bind")
+
+	ErrNoIPv4Address = errors.New("interface has no IPv4 address")
+
+	ErrNoUsableInterfaces = errors.New("no usable interfaces")
+
+	ErrNetworkUnavailable = errors.New("network unavailable")
 )
 
 type ConsumerPacketCallback func(ifi *net.Interface, src net.Addr, payload []byte)
 
+type ConsumerGroupPacketCallback func(ifi *net.Interface, src net.Addr, dst *net.UDPAddr, payload []byte)
+
+// ConsumerPayloadCallback receives only the payloads of a consumer, along
+// with the interface they arrived on.
+type ConsumerPayloadCallback func(ifi *net.Interface, payload []byte)
+
+// AdaptPayloadCallback wraps a ConsumerPayloadCallback into a
+// ConsumerPacketCallback, for callbacks that don't need the sender.
+func AdaptPayloadCallback(cb ConsumerPayloadCallback) ConsumerPacketCallback {
+	return func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		cb(ifi, payload)
+	}
+}
+
+// PacketCallback receives the packets of a consumer. Which goroutines it is
+// invoked from, and whether invocations overlap, is selected with
+// WithCallbackConcurrency.
+type PacketCallback func(pkt *Packet)
+
 type Consumer struct {
-	addr            *net.UDPAddr
-	cb              ConsumerPacketCallback
-	ifis            []*net.Interface
-	ipv4PacketConns map[int]*ipv4.PacketConn
-	mutex           sync.Mutex
-	closed          bool
+	cb     PacketCallback
+	ifis   []*net.Interface
+	opts   consumerOptions
+	ifaces map[int]*ifaceState
+	queues []chan *Packet
+	done   chan struct{}
+
+	// The group and port, changed once if port 0 was requested and the
+	// kernel picked one, while read loops and workers may already run
+	addr atomic.Pointer[net.UDPAddr]
+
+	queuedBytes atomic.Int64
+
+	mutex  sync.Mutex
+	closed bool
+
+	// Why the consumer was closed, and whether Close is still draining
+	stopReason error
+	draining   bool
+
+	// Set while the sockets are closed by WithLazyStart or Deactivate
+	inactive bool
+
+	firstPacket     chan struct{}
+	firstPacketOnce sync.Once
+
+	// Non-nil while delivery is suspended, closed on resume
+	resume chan struct{}
+
+	// The listener that created the consumer, if any
+	listener *Listener
+
+	// The start and end timers of NewScheduledConsumer
+	schedule []Timer
+
+	// Running read loops and workers
+	readers       sync.WaitGroup
+	activeReaders atomic.Int32
+	workers       sync.WaitGroup
+
+	// Recycled payload slabs, if WithPayloadArena is set
+	slabs *sync.Pool
+
+	// Size of the read buffers, see readBufferSize
+	readBuffer atomic.Int32
+
+	// Set by SetSourceFilter, nil for any source
+	sourceFilter atomic.Pointer[sourceFilter]
+}
+
+type ifaceState struct {
+	ifi     *net.Interface
+	sockets []*socket
+	shared  *sharedSocket
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	joined  atomic.Bool
+
+	truncated      atomic.Uint64
+	truncatedBytes atomic.Uint64
+
+	// Unix nanoseconds of the last delivered packet and the last join
+	lastPacket atomic.Int64
+	lastJoin   atomic.Int64
+
+	rejoins atomic.Uint64
+
+	// Unix nanoseconds of a rejoin due to silence that hasn't been followed
+	// by a packet yet
+	silenceRejoin    atomic.Int64
+	suspectedPruning atomic.Bool
+
+	congestionExperienced atomic.Uint64
+
+	queueDrops atomic.Uint64
+
+	sequenceGaps atomic.Uint64
+	reordered    atomic.Uint64
+
+	dstMismatches atomic.Uint64
+
+	duplicates atomic.Uint64
+
+	// Set while the join waits for the network, see WithJoinRetry
+	deferred atomic.Bool
+
+	sourcePortMismatches atomic.Uint64
+
+	invalid atomic.Uint64
+
+	// Kernel drops of sockets that have been closed
+	kernelDrops atomic.Uint64
+
+	errMutex sync.Mutex
+	lastErr  error
+}
+
+// controlInfo holds the values parsed from a datagram's control messages
+// that ipv4.ControlMessage doesn't cover.
+type controlInfo struct {
+	kernelDrops    uint32
+	hasKernelDrops bool
+	tos            byte
+	hasTOS         bool
+
+	timestamp       time.Time
+	timestampSource TimestampSource
+}
+
+type socket struct {
+	iface       *ifaceState
+	conn        *net.UDPConn
+	raw         syscall.RawConn
+	pc          *ipv4.PacketConn
+	kernelDrops atomic.Uint64
+
+	// Set if the destination of datagrams isn't reported on this socket
+	noDstCheck bool
 }
 
-func NewConsumer(addr *net.UDPAddr, ifis []*net.Interface, cb ConsumerPacketCallback) (*Consumer, error) {
+func NewConsumer(addr *net.UDPAddr, ifis []*net.Interface, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
 	if !addr.IP.IsMulticast() {
 		return nil, fmt.Errorf("address %s is not a multicast address", addr.String())
 	}
 
 	c := &Consumer{
-		addr:            addr,
-		cb:              cb,
-		ifis:            ifis,
-		ipv4PacketConns: make(map[int]*ipv4.PacketConn),
+		ifis:   ifis,
+		opts:   defaultConsumerOptions(),
+		ifaces: make(map[int]*ifaceState),
+		done:   make(chan struct{}),
+
+		firstPacket: make(chan struct{}),
+	}
+
+	c.addr.Store(addr)
+
+	for _, ifi := range ifis {
+		c.ifaces[ifi.Index] = &ifaceState{ifi: ifi}
+	}
+
+	c.readBuffer.Store(int32(readBufferSize(ifis)))
+
+	for _, opt := range opts {
+		if err := opt(&c.opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.opts.validate(addr); err != nil {
+		return nil, err
+	}
+
+	if c.opts.name != "" {
+		c.opts.logger = c.opts.logger.With("consumer", c.opts.name)
+	}
+
+	c.cb = c.opts.callback(cb)
+
+	if c.opts.callbackDeadline > 0 {
+		c.cb = c.watchCallback(c.cb)
 	}
 
-	if err := c.start(); err != nil {
+	if c.opts.arenaSize > 0 {
+		c.slabs = newSlabPool(c.opts.arenaSize)
+		c.cb = releaseAfter(c.cb)
+	}
+
+	if c.opts.subnetCheck {
+		c.warnSubnetOverlaps()
+	}
+
+	c.startWorkers()
+
+	if c.opts.lazy {
+		c.inactive = true
+	} else if err := c.startWithTimeout(); err != nil {
+		close(c.done)
 		return nil, err
 	}
 
+	if c.opts.tap != nil {
+		if err := c.opts.tap.start(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if c.opts.rejoinSilence > 0 {
+		go c.silenceMonitor()
+	}
+
+	if c.opts.statsLogInterval > 0 {
+		go c.statsLogger()
+	}
+
+	if c.opts.joinRetryInterval > 0 {
+		go c.joinRetrier()
+	}
+
 	return c, nil
 }
 
+// NewConsumerByLocalIP creates a consumer on the interfaces that own the
+// given local IP addresses.
+func NewConsumerByLocalIP(addr *net.UDPAddr, localIPs []net.IP, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	ifis, err := interfacesByIP(localIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConsumer(addr, ifis, cb, opts...)
+}
+
+// NewConsumerByPattern creates a consumer on the interfaces whose names
+// match pattern, as resolved by InterfacesByPattern at the time of the call.
+// To follow interfaces coming and going, resolve the pattern again and pass
+// the result to SetInterfaces.
+func NewConsumerByPattern(addr *net.UDPAddr, pattern string, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	ifis, err := InterfacesByPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConsumer(addr, ifis, cb, opts...)
+}
+
+func (c *Consumer) startWithTimeout() error {
+	if c.opts.startTimeout <= 0 {
+		return c.start()
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.start()
+	}()
+
+	timer := time.NewTimer(c.opts.startTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-timer.C:
+		// The setup may be stuck in a syscall. Mark the consumer closed so
+		// it stops early, and release whatever it opened once it returns.
+		c.mutex.Lock()
+		c.closed = true
+		c.mutex.Unlock()
+
+		go func() {
+			<-done
+
+			c.mutex.Lock()
+			c.cleanup()
+			c.mutex.Unlock()
+		}()
+
+		return fmt.Errorf("%w after %s", ErrStartTimeout, c.opts.startTimeout)
+	}
+}
+
 func (c *Consumer) start() error {
 	for _, ifi := range c.ifis {
-		if ifi.Flags&net.FlagMulticast == 0 {
-			continue
-		}
+		c.mutex.Lock()
+		closed := c.closed
+		c.mutex.Unlock()
 
-		pc, err := c.openPacketConn(ifi)
-		if err != nil {
+		if closed {
 			c.cleanup()
-			return fmt.Errorf("failed to open multicast socket on interface %s: %w", ifi.Name, err)
+			return ErrStartTimeout
 		}
 
-		if err := pc.SetControlMessage(ipv4.FlagDst, true); err != nil {
+		if err := c.startInterface(c.ifaces[ifi.Index]); err != nil {
 			c.cleanup()
-			return fmt.Errorf("failed to set control message on interface %s: %w", ifi.Name, err)
+			return err
 		}
+	}
 
-		if err := pc.JoinGroup(ifi, c.addr); err != nil {
-			c.cleanup()
-			return fmt.Errorf("failed to join group %s on interface %s: %w", c.addr.String(), ifi.Name, err)
+	if err := c.requireJoined(); err != nil {
+		c.cleanup()
+		return err
+	}
+
+	return nil
+}
+
+// requireJoined fails with ErrNoUsableInterfaces if WithRequireJoin is set
+// and the group wasn't joined on any interface.
+func (c *Consumer) requireJoined() error {
+	if !c.opts.requireJoin {
+		return nil
+	}
+
+	for _, is := range c.ifaces {
+		if is.joined.Load() {
+			return nil
 		}
+	}
+
+	return fmt.Errorf("%w: group %s joined on none of %d interfaces", ErrNoUsableInterfaces, c.Address().String(), len(c.ifaces))
+}
+
+// readBufferSize returns the size of the buffers to read datagrams into.
+// A socket may receive the group's datagrams from any interface it is
+// joined on, so the buffers cover the largest MTU among all of them, and at
+// least a full Ethernet MTU.
+func readBufferSize(ifis []*net.Interface) int {
+	size := maxMTU
+
+	for _, ifi := range ifis {
+		size = max(size, ifi.MTU)
+	}
 
-		c.ipv4PacketConns[ifi.Index] = pc
+	return min(size, maxDatagram)
+}
+
+// growReadBuffer raises the size of the read buffers after a datagram of
+// size bytes didn't fit into a buffer of current bytes, up to the limit set
+// with WithAdaptiveReadBuffer. The read loops pick up the new size before
+// their next read.
+func (c *Consumer) growReadBuffer(size, current int) {
+	limit := c.opts.readBufferLimit
+	if limit == 0 {
+		return
+	}
 
-		go c.readLoop(pc, ifi)
+	// The real size isn't reported on every platform
+	if size <= current {
+		size = limit
+	}
+
+	size = min(size, limit)
+
+	for {
+		old := c.readBuffer.Load()
+		if int(old) >= size || c.readBuffer.CompareAndSwap(old, int32(size)) {
+			return
+		}
+	}
+}
+
+// startInterface opens the sockets of one interface and joins the group on
+// them. On failure, the sockets opened so far are closed again.
+func (c *Consumer) startInterface(is *ifaceState) error {
+	ifi := is.ifi
+
+	is.deferred.Store(false)
+
+	if ifi.Flags&net.FlagMulticast == 0 && !c.opts.ignoreMulticastFlag {
+		return nil
+	}
+
+	if !c.checkAddress(is) {
+		return nil
+	}
+
+	if c.opts.pool != nil {
+		s, err := c.opts.pool.join(c, is)
+		if err != nil {
+			is.setError(err)
+
+			if c.deferJoin(is, err) {
+				return nil
+			}
+
+			return err
+		}
+
+		is.shared = s
+		is.joined.Store(true)
+		is.lastJoin.Store(c.opts.clock.Now().UnixNano())
+
+		if c.opts.verifyJoin {
+			c.verifyJoin(ifi)
+		}
+
+		return nil
+	}
+
+	for i := 0; i < c.opts.fanout; i++ {
+		conn, err := c.openConn(ifi, c.bindIP())
+		if err != nil {
+			err = networkError(fmt.Errorf("failed to open multicast socket on interface %s: %w", ifi.Name, err))
+			is.setError(err)
+			c.stopInterface(is)
+
+			if c.deferJoin(is, err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if local, ok := conn.LocalAddr().(*net.UDPAddr); ok && c.Address().Port == 0 {
+			// Bind all other sockets to the port the kernel picked
+			addr := c.Address()
+			c.addr.Store(&net.UDPAddr{IP: addr.IP, Port: local.Port, Zone: addr.Zone})
+		}
+
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			_ = conn.Close()
+			c.stopInterface(is)
+			return fmt.Errorf("failed to get raw connection on interface %s: %w", ifi.Name, err)
+		}
+
+		s := &socket{
+			iface: is,
+			conn:  conn,
+			raw:   raw,
+			pc:    ipv4.NewPacketConn(conn),
+		}
+		is.sockets = append(is.sockets, s)
+
+		if c.opts.fanout > 1 {
+			if err := setFanoutFilter(s.pc, i, c.opts.fanout); err != nil {
+				c.stopInterface(is)
+				return fmt.Errorf("failed to set fanout filter on interface %s: %w", ifi.Name, err)
+			}
+		}
+
+		if c.opts.filter != nil {
+			if err := s.pc.SetBPF(c.opts.filter); err != nil {
+				c.stopInterface(is)
+				return fmt.Errorf("failed to attach BPF filter on interface %s: %w", ifi.Name, err)
+			}
+		}
+
+		if flags := c.opts.controlFlags(); flags != 0 {
+			if err := s.pc.SetControlMessage(flags, true); err != nil {
+				c.stopInterface(is)
+				return fmt.Errorf("failed to request control messages on interface %s: %w", ifi.Name, err)
+			}
+		} else if !c.opts.trustSocketFiltering {
+			if err := c.dstCheckUnsupported(s, s.pc.SetControlMessage(ipv4.FlagDst, true)); err != nil {
+				c.stopInterface(is)
+				return fmt.Errorf("failed to set control message on interface %s: %w", ifi.Name, err)
+			}
+		}
+
+		if err := c.joinGroup(s.pc, ifi); err != nil {
+			err = c.joinError(ifi, err)
+			is.setError(err)
+			c.stopInterface(is)
+
+			if c.deferJoin(is, err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if c.opts.mux != nil {
+			if err := c.opts.mux.add(c, s); err != nil {
+				c.stopInterface(is)
+				return fmt.Errorf("failed to add socket on interface %s to the multiplexer: %w", ifi.Name, err)
+			}
+
+			continue
+		}
+
+		c.readers.Add(1)
+		c.activeReaders.Add(1)
+		go c.readLoop(s)
+	}
+
+	is.joined.Store(true)
+	is.lastJoin.Store(c.opts.clock.Now().UnixNano())
+
+	if c.opts.verifyJoin {
+		c.verifyJoin(ifi)
 	}
 
 	return nil
 }
 
-func (c *Consumer) readLoop(pc *ipv4.PacketConn, ifi *net.Interface) {
-	buf := make([]byte, maxMTU)
+// checkAddress warns about an interface without an IPv4 address, whose
+// membership reports are sent from 0.0.0.0 and dropped by some switches. It
+// returns false if the interface should be skipped.
+func (c *Consumer) checkAddress(is *ifaceState) bool {
+	ok, err := hasIPv4Address(is.ifi)
+	if err != nil || ok {
+		return true
+	}
+
+	if c.opts.skipUnnumbered {
+		is.setError(fmt.Errorf("skipped interface %s: %w", is.ifi.Name, ErrNoIPv4Address))
+
+		c.opts.logger.Warn("skipping interface without IPv4 address",
+			"group", c.Address().String(),
+			"interface", is.ifi.Name)
+
+		return false
+	}
+
+	c.opts.logger.Warn("joining on interface without IPv4 address, membership reports may be dropped",
+		"group", c.Address().String(),
+		"interface", is.ifi.Name)
+
+	return true
+}
+
+// stopInterface leaves the group and closes the sockets of one interface.
+// Leaving explicitly, rather than leaving it to the kernel when the socket
+// is closed, sends the IGMP leave right away so switches prune promptly.
+func (c *Consumer) stopInterface(is *ifaceState) {
+	for _, s := range is.sockets {
+		if c.opts.mux != nil {
+			c.opts.mux.remove(s)
+		}
+
+		// Best effort, the membership may be gone already
+		_ = s.pc.LeaveGroup(is.ifi, c.Address())
+		_ = s.pc.Close()
+		is.kernelDrops.Add(s.kernelDrops.Load())
+	}
+
+	is.sockets = nil
+
+	if is.shared != nil {
+		is.shared.leave(c)
+		is.shared = nil
+	}
+
+	is.joined.Store(false)
+}
+
+// joinGroup joins the group on pc. A socket that is already a member, e.g.
+// because it is shared with another consumer of the group, is left as it is.
+func (c *Consumer) joinGroup(pc *ipv4.PacketConn, ifi *net.Interface) error {
+	err := c.joinFiltered(pc, ifi, c.loadSourceFilter())
+	if errors.Is(err, syscall.EADDRINUSE) {
+		c.opts.logger.Debug("socket already joined multicast group",
+			"group", c.Address().String(),
+			"interface", ifi.Name)
+
+		return nil
+	}
+
+	return err
+}
+
+func (c *Consumer) joinError(ifi *net.Interface, err error) error {
+	if errors.Is(err, syscall.ENOBUFS) {
+		if limit, lerr := MaxMemberships(); lerr == nil {
+			return fmt.Errorf("%w: failed to join group %s on interface %s, limit is %d: %w",
+				ErrMembershipLimit, c.Address().String(), ifi.Name, limit, err)
+		}
+
+		return fmt.Errorf("%w: failed to join group %s on interface %s: %w",
+			ErrMembershipLimit, c.Address().String(), ifi.Name, err)
+	}
+
+	return networkError(fmt.Errorf("failed to join group %s on interface %s: %w", c.Address().String(), ifi.Name, err))
+}
+
+// dstCheckUnsupported handles the failure to request the destination of
+// datagrams on s, which some minimal kernels lack. A socket bound to the
+// group only receives datagrams sent to it, so it does without the check,
+// while err is returned for sockets bound to another address.
+func (c *Consumer) dstCheckUnsupported(s *socket, err error) error {
+	if err == nil || !c.bindIP().Equal(c.Address().IP) {
+		return err
+	}
+
+	c.opts.logger.Warn("destination of datagrams unavailable, relying on the socket being bound to the group",
+		"group", c.Address().String(),
+		"interface", s.iface.ifi.Name,
+		"error", err)
+
+	s.noDstCheck = true
+
+	return nil
+}
+
+func (c *Consumer) bindIP() net.IP {
+	if c.opts.bindAddress != nil {
+		return c.opts.bindAddress
+	}
+
+	return c.Address().IP
+}
+
+func (c *Consumer) bindWithRetry(bind func() error) error {
+	backoff := c.opts.bindRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := bind()
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) || c.opts.bindRetries == 0 {
+			return err
+		}
+
+		if attempt == c.opts.bindRetries {
+			return fmt.Errorf("%w (%d attempts): %w", ErrBindRetriesExhausted, attempt+1, err)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (c *Consumer) readLoop(s *socket) {
+	defer c.readers.Done()
+	defer c.activeReaders.Add(-1)
+
+	if c.opts.lockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	buf := make([]byte, c.readBuffer.Load())
+	oob := make([]byte, oobSize)
+
+	var payloads *arena
+
+	if c.slabs != nil {
+		payloads = &arena{size: c.opts.arenaSize, pool: c.slabs}
+		defer payloads.close()
+	}
+
+	// Attribute the goroutine to this consumer in profiles
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(
+		"multicast_group", c.Address().String(),
+		"multicast_interface", s.iface.ifi.Name,
+	)))
 
 	for {
 		c.mutex.Lock()
@@ -84,55 +695,643 @@ func (c *Consumer) readLoop(pc *ipv4.PacketConn, ifi *net.Interface) {
 		}
 		c.mutex.Unlock()
 
-		n, cm, src, err := pc.ReadFrom(buf)
+		if !c.waitDelivery() {
+			return
+		}
+
+		// Interfaces with a larger MTU may have been added
+		if size := int(c.readBuffer.Load()); size > len(buf) {
+			buf = make([]byte, size)
+		}
+
+		n, oobn, flags, src, err := s.readMsg(buf, oob)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			// Log error but continue
+			// Record error but continue
+			s.iface.setError(err)
 			continue
 		}
 
+		pkt := c.receive(s, buf, oob[:oobn], n, flags, src, payloads)
+		if pkt == nil {
+			continue
+		}
+
+		// Hold on to a packet read before delivery was suspended
+		if !c.waitDelivery() {
+			return
+		}
+
+		c.deliver(s.iface, pkt)
+	}
+}
+
+// receive turns a datagram read from s into a packet, or returns nil if it
+// is dropped. n is the datagram's real size, which exceeds len(buf) if it
+// was truncated. The payload is copied from buf, carved from payloads if
+// that is set.
+func (c *Consumer) receive(s *socket, buf, oob []byte, n, flags int, src *net.UDPAddr, payloads *arena) *Packet {
+	truncated := flags&msgTrunc != 0
+
+	if truncated {
+		s.iface.truncated.Add(1)
+		c.growReadBuffer(n, len(buf))
+
+		// n is the real datagram size where the platform reports it
+		if n > len(buf) {
+			s.iface.truncatedBytes.Add(uint64(n - len(buf)))
+			n = len(buf)
+		}
+	}
+
+	info := parseControl(oob)
+
+	if c.opts.timestamping && info.timestampSource == TimestampNone {
+		// Take the timestamp in userspace where the kernel has none
+		info.timestamp = c.opts.clock.Now()
+		info.timestampSource = TimestampUserspace
+	}
+
+	if info.hasKernelDrops {
+		s.kernelDrops.Store(uint64(info.kernelDrops))
+	}
+
+	dst := c.Address().IP
+	checkDst := !c.opts.trustSocketFiltering && !s.noDstCheck
+
+	var cm ipv4.ControlMessage
+
+	if checkDst || c.opts.controlFlags() != 0 {
+		if err := cm.Parse(oob); err != nil {
+			return nil
+		}
+	}
+
+	if checkDst {
 		// Check if the destination matches our multicast address
-		if cm != nil && cm.Dst.Equal(c.addr.IP) {
-			// Create a copy of the payload for the callback
-			payload := make([]byte, n)
-			copy(payload, buf[:n])
+		if !cm.Dst.Equal(c.Address().IP) {
+			s.iface.dstMismatches.Add(1)
+
+			c.opts.logger.Debug("dropped packet sent to another destination",
+				"group", c.Address().String(),
+				"interface", s.iface.ifi.Name,
+				"destination", cm.Dst,
+				"source", src)
 
-			c.cb(ifi, src, payload)
+			return nil
 		}
 	}
+
+	if c.unexpectedSource(s.iface, src) {
+		return nil
+	}
+
+	if cm.Dst != nil {
+		dst = cm.Dst
+	}
+
+	var controlMessage *ipv4.ControlMessage
+
+	if c.opts.controlMessage {
+		controlMessage = &cm
+	}
+
+	// Create a copy of the payload for the callback
+	var (
+		payload  []byte
+		fromSlab *slab
+	)
+
+	captured := n
+	if limit := c.opts.maxCaptureBytes; limit > 0 {
+		captured = min(n, limit)
+	}
+
+	if payloads != nil {
+		payload, fromSlab = payloads.copy(buf[:captured])
+	} else {
+		payload = make([]byte, captured)
+		copy(payload, buf[:captured])
+	}
+
+	return &Packet{
+		Src:       src,
+		Dst:       &net.UDPAddr{IP: dst, Port: c.Address().Port},
+		Payload:   payload,
+		Length:    n,
+		Truncated: truncated,
+		TOS:       info.tos,
+		HasTOS:    info.hasTOS,
+
+		Timestamp:         info.timestamp,
+		HardwareTimestamp: info.timestampSource == TimestampHardware,
+		TimestampSource:   info.timestampSource,
+
+		IfIndex:        cm.IfIndex,
+		ControlMessage: controlMessage,
+
+		slab: fromSlab,
+	}
 }
 
-func (c *Consumer) cleanup() {
-	for _, pc := range c.ipv4PacketConns {
-		_ = pc.Close()
+// capture sets the packet's Length if its read path didn't, and cuts the
+// payload short to the size set with WithMaxCaptureBytes.
+func (c *Consumer) capture(pkt *Packet) {
+	if pkt.Length == 0 {
+		pkt.Length = len(pkt.Payload)
+	}
+
+	if limit := c.opts.maxCaptureBytes; limit > 0 && len(pkt.Payload) > limit {
+		pkt.Payload = pkt.Payload[:limit:limit]
+	}
+}
+
+// unexpectedSource reports whether a datagram from src is dropped because
+// it wasn't sent from the port set with WithExpectedSourcePort, and counts
+// it if so.
+func (c *Consumer) unexpectedSource(is *ifaceState, src net.Addr) bool {
+	if c.opts.expectedSourcePort == 0 {
+		return false
+	}
+
+	if addr, ok := src.(*net.UDPAddr); ok && addr.Port == c.opts.expectedSourcePort {
+		return false
+	}
+
+	is.sourcePortMismatches.Add(1)
+
+	return true
+}
+
+func (c *Consumer) deliver(is *ifaceState, pkt *Packet) {
+	pkt.Interface = is.ifi
+
+	c.capture(pkt)
+
+	now := c.opts.clock.Now()
+	is.lastPacket.Store(now.UnixNano())
+
+	if rejoined := is.silenceRejoin.Swap(0); rejoined != 0 {
+		c.checkPruning(is, now.Sub(time.Unix(0, rejoined)))
+	}
+
+	if c.opts.validator != nil && !c.opts.validator(pkt.Payload) {
+		is.invalid.Add(1)
+		pkt.releasePayload()
+
+		return
+	}
+
+	if pkt.HasTOS && pkt.ECN() == ECNCE {
+		is.congestionExperienced.Add(1)
+	}
+
+	if c.opts.sequence != nil {
+		switch c.opts.sequence.check(is, pkt) {
+		case sequenceGap:
+			is.sequenceGaps.Add(1)
+		case sequenceReordered:
+			is.reordered.Add(1)
+		}
+	}
+
+	if c.opts.redundancy != nil && !c.opts.redundancy.accept(pkt) {
+		is.duplicates.Add(1)
+		pkt.releasePayload()
+
+		return
+	}
+
+	// Dropped packets are only counted by the counter of their reason
+	is.packets.Add(1)
+	is.bytes.Add(uint64(pkt.Length))
+
+	c.firstPacketOnce.Do(func() {
+		close(c.firstPacket)
+	})
+
+	if c.opts.tap != nil {
+		c.opts.tap.write(pkt, now)
+	}
+
+	if !c.dispatch(pkt) {
+		is.queueDrops.Add(1)
+		pkt.releasePayload()
+	}
+}
+
+// Inject feeds a packet through the consumer's receive pipeline as if it had
+// been read from the socket on ifi, bypassing the network entirely. The
+// payload is passed on without copying. While delivery is suspended, Inject
+// blocks until it resumes, like the read loops. Injecting into a closed
+// consumer is a no-op.
+func (c *Consumer) Inject(ifi *net.Interface, src net.Addr, payload []byte) {
+	c.mutex.Lock()
+	closed := c.closed
+	is := c.ifaces[ifi.Index]
+	c.mutex.Unlock()
+
+	if closed || !c.waitDelivery() {
+		return
+	}
+
+	if is == nil {
+		// Not one of ours, count it on a throwaway state
+		is = &ifaceState{ifi: ifi}
 	}
 
-	c.ipv4PacketConns = make(map[int]*ipv4.PacketConn)
+	// Dropped before the packet is built, like on the read paths
+	if c.unexpectedSource(is, src) {
+		return
+	}
+
+	c.deliver(is, &Packet{
+		Src:     src,
+		Dst:     c.Address(),
+		Payload: payload,
+	})
+}
+
+func (c *Consumer) cleanup() {
+	for _, is := range c.ifaces {
+		c.stopInterface(is)
+	}
 }
 
 func (c *Consumer) Close() {
+	c.CloseWithError(nil)
+}
+
+// CloseWithError closes the consumer like Close and records err as the
+// reason reported by StopReason, e.g. for a callback that gives up on the
+// stream. A nil err is recorded as net.ErrClosed. Only the first close
+// records its reason. With WithDrainOnClose, this waits for the callback to
+// return and must therefore not be called from it.
+func (c *Consumer) CloseWithError(err error) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
 
 	if c.closed {
+		c.mutex.Unlock()
 		return
 	}
 
+	if err == nil {
+		err = net.ErrClosed
+	}
+
 	c.closed = true
+	c.stopReason = err
+
+	for _, t := range c.schedule {
+		t.Stop()
+	}
+
+	drain := c.opts.drainOnClose && c.queues != nil
+	if drain {
+		// Let suspended read loops hand over what they already read
+		if c.resume != nil {
+			close(c.resume)
+			c.resume = nil
+		}
+
+		c.draining = true
+	} else {
+		close(c.done)
+	}
+
+	c.cleanup()
+	c.mutex.Unlock()
+
+	if drain {
+		c.drain()
+
+		c.mutex.Lock()
+		c.draining = false
+		c.mutex.Unlock()
+	}
+
+	if c.opts.batch != nil {
+		// Flush only once nothing can add to the batches anymore
+		c.readers.Wait()
+		c.workers.Wait()
+
+		c.opts.batch.flushAll()
+	}
+
+	if c.opts.onClose != nil {
+		go c.notifyClose(err)
+	}
+}
+
+// notifyClose calls the WithOnClose function once the read loops and the
+// workers have exited.
+func (c *Consumer) notifyClose(reason error) {
+	c.readers.Wait()
+	c.workers.Wait()
 
-	for _, pc := range c.ipv4PacketConns {
-		_ = pc.Close()
+	c.opts.onClose(reason)
+}
+
+// WaitForFirstPacket blocks until the consumer has received its first packet,
+// ctx is done, or the consumer is closed.
+func (c *Consumer) WaitForFirstPacket(ctx context.Context) error {
+	select {
+	case <-c.firstPacket:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return net.ErrClosed
+	}
+}
+
+// CloseAndRemove closes the consumer and removes it from the listener that
+// created it, if any. Close alone leaves the consumer in the listener until
+// Listener.RemoveConsumer is called.
+func (c *Consumer) CloseAndRemove() {
+	if c.listener != nil {
+		c.listener.RemoveConsumer(c)
+		return
 	}
 
-	c.ipv4PacketConns = make(map[int]*ipv4.PacketConn)
+	c.Close()
+}
+
+// ActiveReaders returns the number of read loops that have been started and
+// haven't returned yet. Read loops return shortly after their sockets are
+// closed, so this drops to zero after Close, which can be used to check for
+// leaked goroutines. Sockets read by WithEpollMultiplexer count as one read
+// loop each, until they are closed and no read of them is pending. Shared
+// sockets are read by the listener instead and not counted.
+func (c *Consumer) ActiveReaders() int {
+	return int(c.activeReaders.Load())
+}
+
+func (c *Consumer) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
 }
 
 func (c *Consumer) Address() *net.UDPAddr {
-	return c.addr
+	return c.addr.Load()
+}
+
+// Name returns the name set with WithName, or an empty string.
+func (c *Consumer) Name() string {
+	return c.opts.name
+}
+
+// String describes the consumer by its name, if it has one, and its group.
+func (c *Consumer) String() string {
+	if c.opts.name == "" {
+		return c.Address().String()
+	}
+
+	return fmt.Sprintf("%s (%s)", c.opts.name, c.Address().String())
+}
+
+// JoinedAddresses returns a copy of the groups currently joined on at least
+// one interface. A consumer receives a single group, so this is either that
+// group or empty, e.g. after Close or while every join is failing.
+func (c *Consumer) JoinedAddresses() []*net.UDPAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	for _, is := range c.ifaces {
+		if is.joined.Load() {
+			addr := *c.Address()
+			addr.IP = slices.Clone(addr.IP)
+
+			return []*net.UDPAddr{&addr}
+		}
+	}
+
+	return nil
 }
 
 func (c *Consumer) Interfaces() []*net.Interface {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	return c.ifis
 }
+
+// SetInterfaces replaces the consumer's interface set. Sockets on interfaces
+// that are no longer in the set are closed, the group is joined on the new
+// ones, and interfaces present in both sets keep receiving without a gap.
+// If any new interface fails to start, the previous set is left in place.
+func (c *Consumer) SetInterfaces(ifis []*net.Interface) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	wanted := make(map[int]bool, len(ifis))
+	added := make([]*ifaceState, 0)
+
+	for _, ifi := range ifis {
+		if wanted[ifi.Index] {
+			continue
+		}
+
+		wanted[ifi.Index] = true
+
+		if _, ok := c.ifaces[ifi.Index]; ok {
+			continue
+		}
+
+		is := &ifaceState{ifi: ifi}
+
+		if c.inactive {
+			added = append(added, is)
+			continue
+		}
+
+		if err := c.startInterface(is); err != nil {
+			for _, is := range added {
+				c.stopInterface(is)
+			}
+
+			return err
+		}
+
+		added = append(added, is)
+	}
+
+	for index, is := range c.ifaces {
+		if !wanted[index] {
+			c.stopInterface(is)
+			delete(c.ifaces, index)
+		}
+	}
+
+	for _, is := range added {
+		c.ifaces[is.ifi.Index] = is
+	}
+
+	c.ifis = ifis
+	size := int32(readBufferSize(ifis))
+	if c.opts.readBufferLimit > 0 {
+		// Keep what the buffers have grown to with WithAdaptiveReadBuffer
+		size = max(size, c.readBuffer.Load())
+	}
+
+	c.readBuffer.Store(size)
+
+	return nil
+}
+
+func (c *Consumer) Stats() Stats {
+	var stats Stats
+
+	for _, is := range c.InterfaceStats() {
+		stats.add(is.Stats)
+	}
+
+	return stats
+}
+
+func (c *Consumer) InterfaceStats() []IfaceStat {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make([]IfaceStat, 0, len(c.ifis))
+
+	for _, ifi := range c.ifis {
+		is := c.ifaces[ifi.Index]
+		stat := is.snapshot()
+		stat.Consumer = c.opts.name
+
+		result = append(result, stat)
+	}
+
+	return result
+}
+
+// BoundPort returns the local port of the sockets on ifi. When the consumer
+// is created with port 0, the kernel picks a free port for the first socket
+// and all other interfaces are bound to the same one, which is then also
+// reported by Address.
+func (c *Consumer) BoundPort(ifi *net.Interface) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	is, ok := c.ifaces[ifi.Index]
+	if !ok {
+		return 0, fmt.Errorf("interface %s is not used by the consumer", ifi.Name)
+	}
+
+	sockets := is.allSockets()
+	if len(sockets) == 0 {
+		return 0, fmt.Errorf("no socket open on interface %s", ifi.Name)
+	}
+
+	local, ok := sockets[0].conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unexpected local address %s", sockets[0].conn.LocalAddr())
+	}
+
+	return local.Port, nil
+}
+
+// RecvQueueBytes returns how much of the receive buffers of the sockets on
+// ifi is in use, summed over all of them. This includes the kernel's
+// overhead per datagram, which is what counts against the buffer size, so
+// a value approaching the buffer size means datagrams are about to be
+// dropped. Only supported on Linux.
+func (c *Consumer) RecvQueueBytes(ifi *net.Interface) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	is, ok := c.ifaces[ifi.Index]
+	if !ok {
+		return 0, fmt.Errorf("interface %s is not used by the consumer", ifi.Name)
+	}
+
+	total := 0
+
+	for _, s := range is.allSockets() {
+		n, err := s.recvQueueBytes()
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+	}
+
+	return total, nil
+}
+
+// ReceiveBufferBytes returns the receive buffer size the kernel granted the
+// sockets on ifi, read back with SO_RCVBUF, to check whether a size set
+// with WithReceiveBuffer was honored. Linux doubles the requested size to
+// account for its bookkeeping overhead and caps it at twice
+// net.core.rmem_max, so an honored request reports twice its size.
+func (c *Consumer) ReceiveBufferBytes(ifi *net.Interface) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	is, ok := c.ifaces[ifi.Index]
+	if !ok {
+		return 0, fmt.Errorf("interface %s is not used by the consumer", ifi.Name)
+	}
+
+	sockets := is.allSockets()
+	if len(sockets) == 0 {
+		return 0, fmt.Errorf("no socket open on interface %s", ifi.Name)
+	}
+
+	return sockets[0].receiveBuffer()
+}
+
+// SetReceiveBufferBytes changes the receive buffer size of the consumer's
+// sockets at runtime, for example to absorb a known burst, and keeps it for
+// sockets opened later on, as WithReceiveBuffer does. The limits described
+// for ReceiveBufferBytes apply. Shared sockets, see WithSharedSockets, are
+// left as they are.
+func (c *Consumer) SetReceiveBufferBytes(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid receive buffer size %d", n)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	c.opts.receiveBuffer = n
+
+	for _, is := range c.ifaces {
+		for _, s := range is.sockets {
+			if err := s.setReceiveBuffer(n); err != nil {
+				return fmt.Errorf("failed to set receive buffer on interface %s: %w", is.ifi.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@
 package multicast
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -11,7 +12,33 @@ import (
 	"golang.org/x/net/ipv4"
 )
 
-func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error) {
+// MaxMemberships returns the maximum number of IPv4 multicast groups a
+// single socket may join. It is only supported on Linux.
+func MaxMemberships() (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func IPReassemblyStats() (ReassemblyStats, error) {
+	return ReassemblyStats{}, errors.ErrUnsupported
+}
+
+func groupMemberships(_ net.IP) ([]int, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func setFanoutFilter(_ *ipv4.PacketConn, _, _ int) error {
+	return errors.New("fanout is only supported on Linux")
+}
+
+func (c *Consumer) openConn(ifi *net.Interface, bindIP net.IP) (*net.UDPConn, error) {
+	if c.opts.bindDevice != "" {
+		return nil, errors.New("binding to a device is only supported on Linux")
+	}
+
+	if c.opts.ecn {
+		return nil, errors.New("reading ECN bits is only supported on Linux")
+	}
+
 	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create socket: %w", err)
@@ -23,10 +50,18 @@ func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error)
 		return nil, fmt.Errorf("failed to set SO_REUSEADDR: %w", err)
 	}
 
-	lsa := syscall.SockaddrInet4{Port: c.addr.Port}
-	copy(lsa.Addr[:], c.addr.IP.To4())
+	if c.opts.receiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.opts.receiveBuffer); err != nil {
+			_ = syscall.Close(s)
+
+			return nil, fmt.Errorf("failed to set SO_RCVBUF: %w", err)
+		}
+	}
 
-	if err := syscall.Bind(s, &lsa); err != nil {
+	lsa := syscall.SockaddrInet4{Port: c.Address().Port}
+	copy(lsa.Addr[:], bindIP.To4())
+
+	if err := c.bindWithRetry(func() error { return syscall.Bind(s, &lsa) }); err != nil {
 		_ = syscall.Close(s)
 
 		return nil, fmt.Errorf("failed to bind socket: %w", err)
@@ -42,5 +77,67 @@ func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error)
 		return nil, fmt.Errorf("failed to create packet conn from file: %w", err)
 	}
 
-	return ipv4.NewPacketConn(conn), nil
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("unexpected packet conn type %T", conn)
+	}
+
+	if c.opts.timestamping {
+		if err := enableTimestamps(udpConn); err != nil {
+			_ = udpConn.Close()
+
+			return nil, fmt.Errorf("failed to set SO_TIMESTAMP: %w", err)
+		}
+	}
+
+	return udpConn, nil
+}
+
+func (s *socket) recvQueueBytes() (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (s *socket) readMsg(buf, oob []byte) (n, oobn, flags int, src *net.UDPAddr, err error) {
+	return s.conn.ReadMsgUDP(buf, oob)
+}
+
+// setReceiveBuffer sets the socket's SO_RCVBUF.
+func (s *socket) setReceiveBuffer(n int) error {
+	var serr error
+
+	err := s.raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(socketFD(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	if serr != nil {
+		return fmt.Errorf("failed to set SO_RCVBUF: %w", serr)
+	}
+
+	return nil
+}
+
+// receiveBuffer reads back the socket's SO_RCVBUF.
+func (s *socket) receiveBuffer() (int, error) {
+	var (
+		size int
+		serr error
+	)
+
+	err := s.raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(socketFD(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if serr != nil {
+		return 0, fmt.Errorf("failed to get SO_RCVBUF: %w", serr)
+	}
+
+	return size, nil
 }
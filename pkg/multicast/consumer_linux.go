@@ -3,15 +3,89 @@
 package multicast
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	"unsafe"
 
+	"golang.org/x/net/bpf"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
 )
 
-func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error) {
+// msgTrunc flags a datagram that didn't fit into the read buffer.
+const msgTrunc = syscall.MSG_TRUNC
+
+const (
+	igmpMaxMembershipsPath = "/proc/sys/net/ipv4/igmp_max_memberships"
+	igmpMembershipsPath    = "/proc/net/igmp"
+	snmpPath               = "/proc/net/snmp"
+)
+
+// MaxMemberships returns the maximum number of IPv4 multicast groups a
+// single socket may join, as configured by net.ipv4.igmp_max_memberships.
+func MaxMemberships() (int, error) {
+	b, err := os.ReadFile(igmpMaxMembershipsPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", igmpMaxMembershipsPath, err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", igmpMaxMembershipsPath, err)
+	}
+
+	return n, nil
+}
+
+// IPReassemblyStats returns the host's IPv4 reassembly counters.
+func IPReassemblyStats() (ReassemblyStats, error) {
+	f, err := os.Open(snmpPath)
+	if err != nil {
+		return ReassemblyStats{}, fmt.Errorf("failed to open %s: %w", snmpPath, err)
+	}
+	defer f.Close()
+
+	return parseReassemblyStats(f)
+}
+
+// groupMemberships returns the indexes of the interfaces the kernel lists a
+// membership of group on.
+func groupMemberships(group net.IP) ([]int, error) {
+	f, err := os.Open(igmpMembershipsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", igmpMembershipsPath, err)
+	}
+	defer f.Close()
+
+	return parseIGMPMemberships(f, group)
+}
+
+func setFanoutFilter(pc *ipv4.PacketConn, index, n int) error {
+	// Every socket joined to the group receives its own copy of each
+	// datagram, so accept it only if its flow hash maps to this socket. All
+	// sockets see the same hash for a given datagram, so exactly one of them
+	// keeps it.
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtRXHash},
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: uint32(n)},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(index), SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffffffff},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to assemble fanout filter: %w", err)
+	}
+
+	return pc.SetBPF(filter)
+}
+
+func (c *Consumer) openConn(ifi *net.Interface, bindIP net.IP) (*net.UDPConn, error) {
 	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create socket: %w", err)
@@ -23,16 +97,65 @@ func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error)
 		return nil, fmt.Errorf("failed to set SO_REUSEADDR: %w", err)
 	}
 
-	if err := syscall.SetsockoptString(s, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifi.Name); err != nil {
+	if c.opts.receiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.opts.receiveBuffer); err != nil {
+			_ = syscall.Close(s)
+
+			return nil, fmt.Errorf("failed to set SO_RCVBUF: %w", err)
+		}
+	}
+
+	// Have the kernel report its drop counter with every datagram
+	if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_RXQ_OVFL, 1); err != nil {
+		_ = syscall.Close(s)
+
+		return nil, fmt.Errorf("failed to set SO_RXQ_OVFL: %w", err)
+	}
+
+	// Only receive the groups joined on this socket, not those joined by
+	// any other socket bound to the same port
+	if !c.opts.multicastAll {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, unix.IP_MULTICAST_ALL, 0); err != nil {
+			_ = syscall.Close(s)
+
+			return nil, fmt.Errorf("failed to clear IP_MULTICAST_ALL: %w", err)
+		}
+	}
+
+	if c.opts.timestamping {
+		flags := unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+			unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+
+		if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err != nil {
+			_ = syscall.Close(s)
+
+			return nil, fmt.Errorf("failed to set SO_TIMESTAMPING: %w", err)
+		}
+	}
+
+	if c.opts.ecn {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1); err != nil {
+			_ = syscall.Close(s)
+
+			return nil, fmt.Errorf("failed to set IP_RECVTOS: %w", err)
+		}
+	}
+
+	device := ifi.Name
+	if c.opts.bindDevice != "" {
+		device = c.opts.bindDevice
+	}
+
+	if err := syscall.SetsockoptString(s, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device); err != nil {
 		_ = syscall.Close(s)
 
 		return nil, fmt.Errorf("failed to set SO_BINDTODEVICE: %w", err)
 	}
 
-	lsa := syscall.SockaddrInet4{Port: c.addr.Port}
-	copy(lsa.Addr[:], c.addr.IP.To4())
+	lsa := syscall.SockaddrInet4{Port: c.Address().Port}
+	copy(lsa.Addr[:], bindIP.To4())
 
-	if err := syscall.Bind(s, &lsa); err != nil {
+	if err := c.bindWithRetry(func() error { return syscall.Bind(s, &lsa) }); err != nil {
 		_ = syscall.Close(s)
 
 		return nil, fmt.Errorf("failed to bind socket: %w", err)
@@ -48,5 +171,150 @@ func (c *Consumer) openPacketConn(ifi *net.Interface) (*ipv4.PacketConn, error)
 		return nil, fmt.Errorf("failed to create packet conn from file: %w", err)
 	}
 
-	return ipv4.NewPacketConn(conn), nil
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+
+		return nil, fmt.Errorf("unexpected packet conn type %T", conn)
+	}
+
+	return udpConn, nil
+}
+
+func parseControl(oob []byte) controlInfo {
+	var info controlInfo
+
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return info
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.SOL_SOCKET && msg.Header.Type == syscall.SO_RXQ_OVFL && len(msg.Data) >= 4:
+			info.kernelDrops = binary.NativeEndian.Uint32(msg.Data)
+			info.hasKernelDrops = true
+
+		case msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TOS && len(msg.Data) >= 1:
+			info.tos = msg.Data[0]
+			info.hasTOS = true
+
+		case msg.Header.Level == syscall.SOL_SOCKET && msg.Header.Type == unix.SCM_TIMESTAMPING:
+			var ts unix.ScmTimestamping
+			if _, err := binary.Decode(msg.Data, binary.NativeEndian, &ts); err != nil {
+				continue
+			}
+
+			// The raw hardware timestamp is in the last slot, the software
+			// one in the first
+			switch {
+			case ts.Ts[2].Nano() != 0:
+				info.timestamp = time.Unix(0, ts.Ts[2].Nano())
+				info.timestampSource = TimestampHardware
+			case ts.Ts[0].Nano() != 0:
+				info.timestamp = time.Unix(0, ts.Ts[0].Nano())
+				info.timestampSource = TimestampKernel
+			}
+		}
+	}
+
+	return info
+}
+
+// recvQueueBytes returns the receive buffer memory in use (SO_MEMINFO).
+// SIOCINQ would only report the size of the next datagram on UDP sockets.
+func (s *socket) recvQueueBytes() (int, error) {
+	var (
+		meminfo [unix.SK_MEMINFO_VARS]uint32
+		errno   syscall.Errno
+	)
+
+	size := uint32(unsafe.Sizeof(meminfo))
+
+	err := s.raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_SOCKET, unix.SO_MEMINFO,
+			uintptr(unsafe.Pointer(&meminfo[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if errno != 0 {
+		return 0, fmt.Errorf("failed to get SO_MEMINFO: %w", errno)
+	}
+
+	return int(meminfo[unix.SK_MEMINFO_RMEM_ALLOC]), nil
+}
+
+// readMsg reads a datagram with MSG_TRUNC, so that n is the real size of the
+// datagram even if it didn't fit into buf.
+func (s *socket) readMsg(buf, oob []byte) (n, oobn, flags int, src *net.UDPAddr, err error) {
+	return s.recvMsg(buf, oob, true)
+}
+
+// tryReadMsg is readMsg failing with syscall.EAGAIN instead of waiting when
+// no datagram is available.
+func (s *socket) tryReadMsg(buf, oob []byte) (n, oobn, flags int, src *net.UDPAddr, err error) {
+	return s.recvMsg(buf, oob, false)
+}
+
+func (s *socket) recvMsg(buf, oob []byte, wait bool) (n, oobn, flags int, src *net.UDPAddr, err error) {
+	var from syscall.Sockaddr
+
+	rerr := s.raw.Read(func(fd uintptr) bool {
+		n, oobn, flags, from, err = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_TRUNC)
+		return !wait || err != syscall.EAGAIN
+	})
+	if rerr != nil {
+		return 0, 0, 0, nil, rerr
+	}
+
+	if err != nil {
+		return 0, 0, 0, nil, os.NewSyscallError("recvmsg", err)
+	}
+
+	if sa, ok := from.(*syscall.SockaddrInet4); ok {
+		src = &net.UDPAddr{IP: net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]), Port: sa.Port}
+	}
+
+	return n, oobn, flags, src, nil
+}
+
+// setReceiveBuffer sets the socket's SO_RCVBUF.
+func (s *socket) setReceiveBuffer(n int) error {
+	var serr error
+
+	err := s.raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, n)
+	})
+	if err != nil {
+		return err
+	}
+
+	if serr != nil {
+		return fmt.Errorf("failed to set SO_RCVBUF: %w", serr)
+	}
+
+	return nil
+}
+
+// receiveBuffer reads back the socket's SO_RCVBUF.
+func (s *socket) receiveBuffer() (int, error) {
+	var (
+		size int
+		serr error
+	)
+
+	err := s.raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if serr != nil {
+		return 0, fmt.Errorf("failed to get SO_RCVBUF: %w", serr)
+	}
+
+	return size, nil
 }
@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package multicast
+
+import (
+	"syscall"
+)
+
+// msgTrunc flags a datagram that didn't fit into the read buffer.
+const msgTrunc = syscall.MSG_TRUNC
+
+// socketFD converts a raw connection's descriptor for the syscall package.
+func socketFD(fd uintptr) int {
+	return int(fd)
+}
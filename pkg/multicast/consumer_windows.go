@@ -0,0 +1,14 @@
+package multicast
+
+import (
+	"syscall"
+)
+
+// msgTrunc is never set, Windows fails the read of a datagram that didn't fit
+// into the read buffer instead of flagging it.
+const msgTrunc = 0
+
+// socketFD converts a raw connection's descriptor for the syscall package.
+func socketFD(fd uintptr) syscall.Handle {
+	return syscall.Handle(fd)
+}
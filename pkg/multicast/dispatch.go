@@ -0,0 +1,152 @@
+package multicast
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"runtime/pprof"
+	"strconv"
+)
+
+const (
+	queueSize = 1024
+)
+
+// startWorkers sets up the queues and the goroutines invoking the callback
+// for the queued delivery modes. Without one, the read loops invoke the
+// callback themselves.
+func (c *Consumer) startWorkers() {
+	switch {
+	case c.opts.workers > 0 && c.opts.sourceOrdering:
+		// One queue per worker, so each source sticks to its worker
+		for i := 0; i < c.opts.workers; i++ {
+			queue := make(chan *Packet, queueSize)
+			c.queues = append(c.queues, queue)
+			c.startWorker(queue, i)
+		}
+
+	case c.opts.workers > 0:
+		queue := make(chan *Packet, queueSize)
+		c.queues = append(c.queues, queue)
+
+		for i := 0; i < c.opts.workers; i++ {
+			c.startWorker(queue, i)
+		}
+
+	case c.opts.serial:
+		queue := make(chan *Packet, queueSize)
+		c.queues = append(c.queues, queue)
+		c.startWorker(queue, 0)
+	}
+}
+
+func (c *Consumer) startWorker(queue chan *Packet, index int) {
+	c.workers.Add(1)
+
+	go c.workerLoop(queue, index)
+}
+
+// dispatch hands the packet to the callback, directly or through a queue.
+// It returns false if the packet was dropped because the queues hold too
+// many bytes.
+func (c *Consumer) dispatch(p *Packet) bool {
+	if c.queues == nil {
+		c.cb(p)
+		return true
+	}
+
+	size := int64(len(p.Payload))
+
+	if max := c.opts.maxQueuedBytes; max > 0 {
+		if c.queuedBytes.Add(size) > int64(max) {
+			c.queuedBytes.Add(-size)
+			return false
+		}
+	}
+
+	queue := c.queues[0]
+	if len(c.queues) > 1 {
+		queue = c.queues[sourceHash(p.Src)%uint32(len(c.queues))]
+	}
+
+	select {
+	case queue <- p:
+	case <-c.done:
+		// Never dequeued, so release its share of the limit and its
+		// payload here
+		if c.opts.maxQueuedBytes > 0 {
+			c.queuedBytes.Add(-size)
+		}
+
+		p.releasePayload()
+	}
+
+	return true
+}
+
+// sourceHash maps a sender's address to a stable value.
+func sourceHash(src net.Addr) uint32 {
+	h := fnv.New32a()
+
+	if addr, ok := src.(*net.UDPAddr); ok {
+		_, _ = h.Write(addr.IP.To16())
+		_, _ = h.Write([]byte{byte(addr.Port >> 8), byte(addr.Port)})
+	} else if src != nil {
+		_, _ = h.Write([]byte(src.String()))
+	}
+
+	return h.Sum32()
+}
+
+func (c *Consumer) dequeued(p *Packet) {
+	if c.opts.maxQueuedBytes > 0 {
+		c.queuedBytes.Add(-int64(len(p.Payload)))
+	}
+}
+
+func (c *Consumer) workerLoop(queue chan *Packet, index int) {
+	defer c.workers.Done()
+
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(
+		"multicast_group", c.Address().String(),
+		"multicast_worker", strconv.Itoa(index),
+	)))
+
+	for {
+		select {
+		case p := <-queue:
+			c.dequeued(p)
+			c.cb(p)
+
+		case <-c.done:
+			if c.opts.drainOnClose {
+				c.flushQueue(queue)
+			}
+
+			return
+		}
+	}
+}
+
+// flushQueue delivers the packets left in the queue.
+func (c *Consumer) flushQueue(queue chan *Packet) {
+	for {
+		select {
+		case p := <-queue:
+			c.dequeued(p)
+			c.cb(p)
+
+		default:
+			return
+		}
+	}
+}
+
+// drain waits for the read loops, whose sockets are already closed, to queue
+// the packets they have read, and then for the workers to deliver
+// everything queued.
+func (c *Consumer) drain() {
+	c.readers.Wait()
+	close(c.done)
+	c.workers.Wait()
+}
@@ -0,0 +1,121 @@
+package multicast
+
+import (
+	"bytes"
+	"net"
+	"sync"
+)
+
+// Dispatcher routes received packets to handlers by the group and port they
+// were sent to. It is what lets a listener share one socket between the
+// consumers of many groups, and can likewise be put behind sockets of one's
+// own, such as one bound to the wildcard address that joins several groups.
+// Both the group and the port have to match, so a handler never sees
+// another port's traffic even if the kernel hands it to the same socket.
+type Dispatcher struct {
+	mutex  sync.RWMutex
+	routes map[routeKey][]*dispatchRoute
+}
+
+// routeKey is the group and port packets are routed by.
+type routeKey struct {
+	group string
+	port  int
+}
+
+func newRouteKey(addr *net.UDPAddr) routeKey {
+	return routeKey{group: addr.IP.String(), port: addr.Port}
+}
+
+type dispatchRoute struct {
+	handler PacketCallback
+}
+
+// NewDispatcher returns a dispatcher without any handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		routes: make(map[routeKey][]*dispatchRoute),
+	}
+}
+
+// Handle registers handler for the packets sent to the group and port of
+// addr, and returns a function removing it again. Several handlers may be
+// registered for the same destination.
+func (d *Dispatcher) Handle(addr *net.UDPAddr, handler PacketCallback) func() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := newRouteKey(addr)
+	r := &dispatchRoute{handler: handler}
+
+	d.routes[key] = append(d.routes[key], r)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			d.remove(key, r)
+		})
+	}
+}
+
+func (d *Dispatcher) remove(key routeKey, r *dispatchRoute) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var routes []*dispatchRoute
+
+	for _, other := range d.routes[key] {
+		if other != r {
+			routes = append(routes, other)
+		}
+	}
+
+	if len(routes) == 0 {
+		delete(d.routes, key)
+	} else {
+		d.routes[key] = routes
+	}
+}
+
+// Handlers returns the number of handlers registered for the group and port
+// of addr.
+func (d *Dispatcher) Handlers(addr *net.UDPAddr) int {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return len(d.routes[newRouteKey(addr)])
+}
+
+// Len returns the number of destinations with at least one handler.
+func (d *Dispatcher) Len() int {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return len(d.routes)
+}
+
+// Dispatch hands pkt to the handlers registered for its Dst and reports
+// whether there were any. Every handler gets its own copy of the packet and
+// its payload, which it may keep, so the caller may reuse its buffer once
+// Dispatch returns. The handlers are invoked in the order they were
+// registered, from the calling goroutine.
+func (d *Dispatcher) Dispatch(pkt *Packet) bool {
+	if pkt.Dst == nil {
+		return false
+	}
+
+	d.mutex.RLock()
+	routes := d.routes[newRouteKey(pkt.Dst)]
+	d.mutex.RUnlock()
+
+	for _, r := range routes {
+		p := *pkt
+		p.Payload = bytes.Clone(pkt.Payload)
+		p.slab = nil
+
+		r.handler(&p)
+	}
+
+	return len(routes) > 0
+}
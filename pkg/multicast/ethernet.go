@@ -0,0 +1,91 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/bpf"
+)
+
+// EthernetFrameCallback receives a raw ethernet frame, starting with the
+// destination MAC address.
+type EthernetFrameCallback func(ifi *net.Interface, frame []byte)
+
+// EthernetConsumer receives raw ethernet frames sent to a multicast MAC
+// address, for layer 2 protocols that don't run over IP. Only supported on
+// Linux, and requires CAP_NET_RAW.
+type EthernetConsumer struct {
+	addr    net.HardwareAddr
+	ifis    []*net.Interface
+	cb      EthernetFrameCallback
+	sockets []*ethernetSocket
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// NewEthernetConsumer joins the multicast MAC address addr on the given
+// interfaces and delivers every frame sent to it.
+func NewEthernetConsumer(addr net.HardwareAddr, ifis []*net.Interface, cb EthernetFrameCallback) (*EthernetConsumer, error) {
+	if len(addr) != 6 || addr[0]&0x01 == 0 {
+		return nil, fmt.Errorf("address %s is not an ethernet multicast address", addr.String())
+	}
+
+	ec := &EthernetConsumer{
+		addr: addr,
+		ifis: ifis,
+		cb:   cb,
+	}
+
+	for _, ifi := range ifis {
+		s, err := ec.openSocket(ifi)
+		if err != nil {
+			ec.Close()
+			return nil, fmt.Errorf("failed to open ethernet socket on interface %s: %w", ifi.Name, err)
+		}
+
+		ec.sockets = append(ec.sockets, s)
+
+		go ec.readLoop(s)
+	}
+
+	return ec, nil
+}
+
+func (ec *EthernetConsumer) Close() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	if ec.closed {
+		return
+	}
+
+	ec.closed = true
+
+	for _, s := range ec.sockets {
+		s.close()
+	}
+
+	ec.sockets = nil
+}
+
+func (ec *EthernetConsumer) Address() net.HardwareAddr {
+	return ec.addr
+}
+
+func (ec *EthernetConsumer) Interfaces() []*net.Interface {
+	return ec.ifis
+}
+
+// filter matches frames whose destination is the consumer's address.
+func (ec *EthernetConsumer) filter() ([]bpf.RawInstruction, error) {
+	return bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: binary.BigEndian.Uint32(ec.addr[0:4]), SkipTrue: 3},
+		bpf.LoadAbsolute{Off: 4, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(binary.BigEndian.Uint16(ec.addr[4:6])), SkipTrue: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+}
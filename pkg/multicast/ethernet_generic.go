@@ -0,0 +1,18 @@
+//go:build !linux
+
+package multicast
+
+import (
+	"errors"
+	"net"
+)
+
+type ethernetSocket struct{}
+
+func (ec *EthernetConsumer) openSocket(_ *net.Interface) (*ethernetSocket, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (ec *EthernetConsumer) readLoop(_ *ethernetSocket) {}
+
+func (s *ethernetSocket) close() {}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package multicast
+
+import (
+	"bytes"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+type ethernetSocket struct {
+	ifi  *net.Interface
+	file *os.File
+}
+
+func (ec *EthernetConsumer) openSocket(ifi *net.Interface) (*ethernetSocket, error) {
+	filter, err := ec.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	// Raw packet socket, so reads start at the ethernet header
+	f, err := openPacketSocket(ifi, unix.SOCK_RAW, unix.ETH_P_ALL, filter, ec.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ethernetSocket{ifi: ifi, file: f}, nil
+}
+
+func (ec *EthernetConsumer) readLoop(s *ethernetSocket) {
+	readPacketSocket(s.file, func(frame []byte) {
+		ec.cb(s.ifi, bytes.Clone(frame))
+	})
+}
+
+func (s *ethernetSocket) close() {
+	_ = s.file.Close()
+}
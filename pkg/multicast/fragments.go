@@ -0,0 +1,92 @@
+package multicast
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReassemblyStats are the host-wide IPv4 reassembly counters of the kernel.
+// The socket layer only ever sees reassembled datagrams, so these counters
+// are the way to tell whether received traffic arrived fragmented. They
+// can't be attributed to a socket; compare them before and after a test
+// with otherwise quiet traffic. Datagrams larger than the read buffer are
+// additionally reported as Packet.Truncated, and since that buffer covers at
+// least the largest MTU of the consumer's interfaces, such datagrams must
+// have been fragmented on the way.
+type ReassemblyStats struct {
+	// Required is the number of received fragments that needed reassembly
+	Required uint64
+
+	// OK is the number of datagrams successfully reassembled
+	OK uint64
+
+	// Failed is the number of reassembly failures, e.g. lost fragments
+	Failed uint64
+
+	// Timeout is the number of seconds fragments are held for reassembly
+	Timeout uint64
+}
+
+// parseReassemblyStats reads the reassembly counters from the Ip lines of
+// Linux's /proc/net/snmp, a header line with the counter names followed by
+// a line with their values.
+func parseReassemblyStats(r io.Reader) (ReassemblyStats, error) {
+	var (
+		stats  ReassemblyStats
+		header []string
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "Ip:" {
+			continue
+		}
+
+		if header == nil {
+			header = fields
+			continue
+		}
+
+		if len(fields) != len(header) {
+			return stats, errors.New("mismatching Ip counter lines")
+		}
+
+		for i, name := range header {
+			var counter *uint64
+
+			switch name {
+			case "ReasmReqds":
+				counter = &stats.Required
+			case "ReasmOKs":
+				counter = &stats.OK
+			case "ReasmFails":
+				counter = &stats.Failed
+			case "ReasmTimeout":
+				counter = &stats.Timeout
+			default:
+				continue
+			}
+
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return stats, fmt.Errorf("failed to parse %s: %w", name, err)
+			}
+
+			*counter = v
+		}
+
+		return stats, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	return stats, errors.New("no Ip counters found")
+}
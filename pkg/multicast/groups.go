@@ -0,0 +1,79 @@
+package multicast
+
+import (
+	"net"
+)
+
+var (
+	AllSystemsGroup = net.IPv4(224, 0, 0, 1)
+	AllRoutersGroup = net.IPv4(224, 0, 0, 2)
+	IGMPv3Group     = net.IPv4(224, 0, 0, 22)
+	MDNSGroup       = net.IPv4(224, 0, 0, 251)
+	LLMNRGroup      = net.IPv4(224, 0, 0, 252)
+	NTPGroup        = net.IPv4(224, 0, 1, 1)
+	PTPPrimaryGroup = net.IPv4(224, 0, 1, 129)
+	PTPPdelayGroup  = net.IPv4(224, 0, 0, 107)
+	SSDPGroup       = net.IPv4(239, 255, 255, 250)
+
+	linkLocalNet = &net.IPNet{IP: net.IPv4(224, 0, 0, 0).To4(), Mask: net.CIDRMask(24, 32)}
+)
+
+const (
+	MDNSPort = 5353
+	SSDPPort = 1900
+)
+
+type WellKnownGroup struct {
+	Name string
+	IP   net.IP
+
+	// Port is the UDP port the group is used with, or 0 if there is none
+	Port int
+
+	Description string
+}
+
+func (g WellKnownGroup) UDPAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: g.IP, Port: g.Port}
+}
+
+// WellKnownGroups returns commonly used IPv4 multicast groups.
+func WellKnownGroups() []WellKnownGroup {
+	return []WellKnownGroup{
+		{"all-systems", AllSystemsGroup, 0, "All hosts on the local network segment"},
+		{"all-routers", AllRoutersGroup, 0, "All routers on the local network segment"},
+		{"igmpv3", IGMPv3Group, 0, "IGMPv3 membership reports"},
+		{"mdns", MDNSGroup, MDNSPort, "Multicast DNS"},
+		{"llmnr", LLMNRGroup, 5355, "Link-Local Multicast Name Resolution"},
+		{"ntp", NTPGroup, 123, "Network Time Protocol"},
+		{"ptp-primary", PTPPrimaryGroup, 319, "Precision Time Protocol, all messages except peer delay"},
+		{"ptp-pdelay", PTPPdelayGroup, 319, "Precision Time Protocol, peer delay messages"},
+		{"ssdp", SSDPGroup, SSDPPort, "Simple Service Discovery Protocol"},
+	}
+}
+
+// IsLinkLocalGroup reports whether ip is in the link-local multicast block
+// 224.0.0.0/24. Routers never forward these groups, so their traffic stays
+// on the local segment regardless of the sender's TTL.
+func IsLinkLocalGroup(ip net.IP) bool {
+	return linkLocalNet.Contains(ip)
+}
+
+// ssdpReceiveBuffer absorbs the bursts of announcements that follow an
+// M-SEARCH, when every device on the network answers at once.
+const ssdpReceiveBuffer = 1 << 20
+
+// NewMDNSConsumer creates a consumer of Multicast DNS traffic on
+// 224.0.0.251:5353.
+func NewMDNSConsumer(ifis []*net.Interface, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	return NewConsumer(&net.UDPAddr{IP: MDNSGroup, Port: MDNSPort}, ifis, cb, opts...)
+}
+
+// NewSSDPConsumer creates a consumer of Simple Service Discovery Protocol
+// traffic on 239.255.255.250:1900 with an enlarged receive buffer, which can
+// be overridden with WithReceiveBuffer.
+func NewSSDPConsumer(ifis []*net.Interface, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	opts = append([]ConsumerOption{WithReceiveBuffer(ssdpReceiveBuffer)}, opts...)
+
+	return NewConsumer(&net.UDPAddr{IP: SSDPGroup, Port: SSDPPort}, ifis, cb, opts...)
+}
@@ -0,0 +1,213 @@
+package multicast
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+// InterfacesByPattern returns the interfaces whose names match pattern, in
+// the syntax of filepath.Match, e.g. "eth0.*" for the VLANs of eth0.
+func InterfacesByPattern(pattern string) ([]*net.Interface, error) {
+	// Reject malformed patterns even if there are no interfaces to match
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces: %w", err)
+	}
+
+	var result []*net.Interface
+
+	for i := range ifis {
+		if ok, _ := filepath.Match(pattern, ifis[i].Name); ok {
+			result = append(result, &ifis[i])
+		}
+	}
+
+	return result, nil
+}
+
+func interfacesByIP(ips []net.IP) ([]*net.Interface, error) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces: %w", err)
+	}
+
+	var result []*net.Interface
+	seen := make(map[int]bool)
+
+	for _, ip := range ips {
+		ifi, err := interfaceByIP(ifis, ip)
+		if err != nil {
+			return nil, err
+		}
+
+		// Several addresses may live on the same interface
+		if seen[ifi.Index] {
+			continue
+		}
+
+		seen[ifi.Index] = true
+		result = append(result, ifi)
+	}
+
+	return result, nil
+}
+
+// hasIPv4Address reports whether an IPv4 address is assigned to ifi.
+func hasIPv4Address(ifi *net.Interface) (bool, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false, fmt.Errorf("failed to get addresses of interface %s: %w", ifi.Name, err)
+	}
+
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func interfaceByIP(ifis []net.Interface, ip net.IP) (*net.Interface, error) {
+	for i := range ifis {
+		addrs, err := ifis[i].Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get addresses of interface %s: %w", ifis[i].Name, err)
+		}
+
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return &ifis[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no interface found with address %s", ip.String())
+}
+
+// subnetOverlap is a pair of interfaces with addresses in the same IPv4
+// subnet, which likely puts them on the same layer 2 network.
+type subnetOverlap struct {
+	a, b   *net.Interface
+	subnet *net.IPNet
+}
+
+// findSubnetOverlaps returns the pairs of interfaces that share an IPv4
+// subnet, given the addresses of each interface.
+func findSubnetOverlaps(ifis []*net.Interface, addrs map[int][]*net.IPNet) []subnetOverlap {
+	var overlaps []subnetOverlap
+
+	for i, a := range ifis {
+		for _, b := range ifis[i+1:] {
+			if subnet := sharedSubnet(addrs[a.Index], addrs[b.Index]); subnet != nil {
+				overlaps = append(overlaps, subnetOverlap{a: a, b: b, subnet: subnet})
+			}
+		}
+	}
+
+	return overlaps
+}
+
+func sharedSubnet(as, bs []*net.IPNet) *net.IPNet {
+	for _, a := range as {
+		if a.IP.To4() == nil {
+			continue
+		}
+
+		for _, b := range bs {
+			if b.IP.To4() != nil && a.Contains(b.IP) && b.Contains(a.IP) {
+				return &net.IPNet{IP: a.IP.Mask(a.Mask), Mask: a.Mask}
+			}
+		}
+	}
+
+	return nil
+}
+
+// warnSubnetOverlaps logs a warning for every pair of the consumer's
+// interfaces that share a subnet, as a group joined on both is likely
+// delivered twice.
+func (c *Consumer) warnSubnetOverlaps() {
+	addrs := make(map[int][]*net.IPNet, len(c.ifis))
+
+	for _, ifi := range c.ifis {
+		ifiAddrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range ifiAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				addrs[ifi.Index] = append(addrs[ifi.Index], ipNet)
+			}
+		}
+	}
+
+	for _, o := range findSubnetOverlaps(c.ifis, addrs) {
+		c.opts.logger.Warn("interfaces share a subnet, packets may be delivered twice",
+			"group", c.Address().String(),
+			"interfaces", []string{o.a.Name, o.b.Name},
+			"subnet", o.subnet.String())
+	}
+}
+
+// IfaceInfo describes a multicast capable interface.
+type IfaceInfo struct {
+	// Interface can be passed on to NewConsumer
+	Interface *net.Interface
+
+	Name  string
+	Index int
+	MTU   int
+	Up    bool
+
+	// Addrs are the addresses assigned to the interface
+	Addrs []*net.IPNet
+}
+
+// InterfaceInfo lists the interfaces that support multicast along with
+// their addresses, e.g. to let a user pick the interfaces to join on.
+func InterfaceInfo() ([]IfaceInfo, error) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces: %w", err)
+	}
+
+	var infos []IfaceInfo
+
+	for i := range ifis {
+		ifi := &ifis[i]
+
+		if ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get addresses of interface %s: %w", ifi.Name, err)
+		}
+
+		info := IfaceInfo{
+			Interface: ifi,
+			Name:      ifi.Name,
+			Index:     ifi.Index,
+			MTU:       ifi.MTU,
+			Up:        ifi.Flags&net.FlagUp != 0,
+		}
+
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				info.Addrs = append(info.Addrs, ipNet)
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,81 @@
+package multicast
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// networkError marks err with ErrNetworkUnavailable if it was caused by the
+// network, or the interface, not being up yet.
+func networkError(err error) error {
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.ENETDOWN) || errors.Is(err, syscall.ENODEV) {
+		return fmt.Errorf("%w: %w", ErrNetworkUnavailable, err)
+	}
+
+	return err
+}
+
+// deferJoin leaves the join on is to the retries of WithJoinRetry if it
+// failed because the network is unavailable, and reports whether it did.
+func (c *Consumer) deferJoin(is *ifaceState, err error) bool {
+	if c.opts.joinRetryInterval <= 0 || !errors.Is(err, ErrNetworkUnavailable) {
+		return false
+	}
+
+	is.deferred.Store(true)
+
+	c.opts.logger.Warn("network unavailable, deferring multicast join",
+		"group", c.Address().String(),
+		"interface", is.ifi.Name,
+		"error", err)
+
+	return true
+}
+
+// joinRetrier retries the deferred joins every configured interval until the
+// consumer is closed.
+func (c *Consumer) joinRetrier() {
+	ticker := c.opts.clock.NewTicker(c.opts.joinRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case <-ticker.C():
+			c.retryDeferredJoins()
+		}
+	}
+}
+
+func (c *Consumer) retryDeferredJoins() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || c.inactive {
+		return
+	}
+
+	for _, is := range c.ifaces {
+		if !is.deferred.Load() {
+			continue
+		}
+
+		if err := c.startInterface(is); err != nil {
+			c.opts.logger.Warn("failed to join multicast group after deferring",
+				"group", c.Address().String(),
+				"interface", is.ifi.Name,
+				"error", err)
+
+			continue
+		}
+
+		if is.joined.Load() {
+			c.opts.logger.Info("joined multicast group after network became available",
+				"group", c.Address().String(),
+				"interface", is.ifi.Name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package multicast
+
+import (
+	"net"
+)
+
+// Activate opens the consumer's sockets and joins the group on its
+// interfaces, if they were left closed by WithLazyStart or Deactivate. If an
+// interface fails to start, the others are stopped again and the consumer
+// stays inactive.
+func (c *Consumer) Activate() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if !c.inactive {
+		return nil
+	}
+
+	for _, ifi := range c.ifis {
+		if err := c.startInterface(c.ifaces[ifi.Index]); err != nil {
+			c.cleanup()
+			return err
+		}
+	}
+
+	if err := c.requireJoined(); err != nil {
+		c.cleanup()
+		return err
+	}
+
+	c.inactive = false
+
+	return nil
+}
+
+// Deactivate leaves the group and closes the consumer's sockets, while
+// keeping the consumer and its statistics for a later Activate. Packets
+// still being delivered are not waited for.
+func (c *Consumer) Deactivate() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if c.inactive {
+		return nil
+	}
+
+	c.cleanup()
+	c.inactive = true
+
+	return nil
+}
+
+// Active reports whether the consumer's sockets are open, which is the case
+// unless it was created with WithLazyStart or deactivated, or is closed.
+func (c *Consumer) Active() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return !c.closed && !c.inactive
+}
@@ -1,7 +1,10 @@
 package multicast
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"slices"
 	"sync"
 )
 
@@ -9,28 +12,150 @@ type Listener struct {
 	mutex     sync.RWMutex
 	ifis      []*net.Interface
 	consumers []*Consumer
+	pool      *socketPool
+	mux       *multiplexer
+
+	maxConsumers int
+
+	// Consumers being created, counted against maxConsumers
+	pending int
+}
+
+var ErrTooManyConsumers = errors.New("too many consumers")
+
+type ListenerOption func(*Listener)
+
+// WithSharedSockets makes the listener's consumers share one socket per
+// interface and port instead of opening their own. The socket joins the
+// groups of all consumers on that port and hands each datagram to the
+// consumers of the group it was sent to, which saves file descriptors and
+// control message parsing when, for example, mDNS and SSDP are received
+// side by side. Shared sockets can't be combined with the options that
+// configure sockets individually, such as WithFanout or WithBindAddress,
+// and packets arriving while a consumer's delivery is suspended are
+// discarded rather than buffered.
+func WithSharedSockets() ListenerOption {
+	return func(l *Listener) {
+		l.pool = newSocketPool()
+	}
+}
+
+// WithMaxConsumers limits the listener to n open consumers. Adding more
+// fails with ErrTooManyConsumers until some are closed.
+func WithMaxConsumers(n int) ListenerOption {
+	return func(l *Listener) {
+		l.maxConsumers = n
+	}
+}
+
+// WithEpollMultiplexer reads the sockets of all the listener's consumers from
+// a single goroutine waiting on an epoll instance, instead of a goroutine
+// per socket, which scales to thousands of groups. The callbacks are then
+// invoked from that goroutine unless a consumer queues its packets, so a
+// slow callback holds up all consumers, and WithPayloadArena and
+// WithLockOSThread have no effect. Sockets shared by WithSharedSockets keep
+// their own goroutine. Where epoll isn't available, the consumers fall back
+// to a goroutine per socket.
+func WithEpollMultiplexer() ListenerOption {
+	return func(l *Listener) {
+		if mux, err := newMultiplexer(); err == nil {
+			l.mux = mux
+		}
+	}
+}
+
+// withMultiplexer makes the consumer's sockets read by the multiplexer.
+func withMultiplexer(m *multiplexer) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.mux = m
+
+		return nil
+	}
 }
 
-func NewListener(ifis []*net.Interface) *Listener {
-	return &Listener{
+func NewListener(ifis []*net.Interface, opts ...ListenerOption) *Listener {
+	l := &Listener{
 		ifis:      ifis,
 		consumers: make([]*Consumer, 0),
 	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
 }
 
-func (l *Listener) AddConsumer(addr *net.UDPAddr, cb ConsumerPacketCallback) (*Consumer, error) {
-	consumer, err := NewConsumer(addr, l.ifis, cb)
-	if err != nil {
+func (l *Listener) AddConsumer(addr *net.UDPAddr, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	return l.addConsumer(addr, l.ifis, cb, opts)
+}
+
+// AddConsumerOn adds a consumer that only joins the group on ifis, which
+// must be a subset of the listener's interfaces.
+func (l *Listener) AddConsumerOn(addr *net.UDPAddr, ifis []*net.Interface, cb ConsumerPacketCallback, opts ...ConsumerOption) (*Consumer, error) {
+	for _, ifi := range ifis {
+		if !slices.ContainsFunc(l.ifis, func(own *net.Interface) bool { return own.Index == ifi.Index }) {
+			return nil, fmt.Errorf("interface %s is not one of the listener's interfaces", ifi.Name)
+		}
+	}
+
+	return l.addConsumer(addr, ifis, cb, opts)
+}
+
+func (l *Listener) addConsumer(addr *net.UDPAddr, ifis []*net.Interface, cb ConsumerPacketCallback, opts []ConsumerOption) (*Consumer, error) {
+	if l.pool != nil {
+		opts = append(slices.Clip(opts), withSocketPool(l.pool))
+	}
+
+	if l.mux != nil {
+		opts = append(slices.Clip(opts), withMultiplexer(l.mux))
+	}
+
+	if err := l.reserve(); err != nil {
 		return nil, err
 	}
 
+	consumer, err := NewConsumer(addr, ifis, cb, opts...)
+
 	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.pending--
+
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.listener = l
 	l.consumers = append(l.consumers, consumer)
-	l.mutex.Unlock()
 
 	return consumer, nil
 }
 
+// reserve counts a consumer about to be created against the limit.
+func (l *Listener) reserve() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxConsumers > 0 {
+		open := l.pending
+
+		for _, consumer := range l.consumers {
+			if !consumer.isClosed() {
+				open++
+			}
+		}
+
+		if open >= l.maxConsumers {
+			return fmt.Errorf("%w: limit is %d", ErrTooManyConsumers, l.maxConsumers)
+		}
+	}
+
+	l.pending++
+
+	return nil
+}
+
 func (l *Listener) RemoveConsumer(consumer *Consumer) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
@@ -55,6 +180,10 @@ func (l *Listener) Close() {
 	}
 
 	l.consumers = make([]*Consumer, 0)
+
+	if l.mux != nil {
+		l.mux.close()
+	}
 }
 
 func (l *Listener) Interfaces() []*net.Interface {
@@ -71,3 +200,30 @@ func (l *Listener) Consumers() []*Consumer {
 
 	return result
 }
+
+// ForEachConsumer calls fn for each of the listener's consumers. It works on
+// a snapshot taken when it is called, so fn may add and remove consumers,
+// including the one it was called for, without affecting the iteration.
+func (l *Listener) ForEachConsumer(fn func(*Consumer)) {
+	for _, consumer := range l.Consumers() {
+		fn(consumer)
+	}
+}
+
+// Stats sums the statistics of all consumers of the listener, including
+// closed ones that have not been removed yet.
+func (l *Listener) Stats() ListenerStats {
+	stats := ListenerStats{
+		Interfaces: len(l.ifis),
+	}
+
+	for _, consumer := range l.Consumers() {
+		stats.add(consumer.Stats())
+
+		if !consumer.isClosed() {
+			stats.Consumers++
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,92 @@
+package multicast
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseIGMPMemberships returns the indexes of the interfaces group is
+// joined on, as listed in the format of Linux's /proc/net/igmp. Each
+// interface line is followed by one tab-indented line per group, which holds
+// the group address as the hexadecimal value of its network-order bytes in
+// host byte order.
+func parseIGMPMemberships(r io.Reader, group net.IP) ([]int, error) {
+	group4 := group.To4()
+	if group4 == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address", group)
+	}
+
+	var (
+		indexes []int
+		current = -1
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "\t") {
+			// An interface line, or the header
+			index, err := strconv.Atoi(fields[0])
+			if err != nil {
+				current = -1
+				continue
+			}
+
+			current = index
+
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil || current < 0 {
+			continue
+		}
+
+		addr := make(net.IP, 4)
+		binary.NativeEndian.PutUint32(addr, uint32(value))
+
+		if addr.Equal(group4) && !slices.Contains(indexes, current) {
+			indexes = append(indexes, current)
+		}
+	}
+
+	return indexes, scanner.Err()
+}
+
+// verifyJoin warns if the kernel doesn't list the group's membership on the
+// interface it was joined on. Platforms that don't expose the memberships
+// are not verified.
+func (c *Consumer) verifyJoin(ifi *net.Interface) {
+	indexes, err := groupMemberships(c.Address().IP)
+	if err != nil {
+		c.opts.logger.Debug("failed to verify multicast membership",
+			"group", c.Address().String(),
+			"interface", ifi.Name,
+			"error", err)
+
+		return
+	}
+
+	if slices.Contains(indexes, ifi.Index) {
+		return
+	}
+
+	c.opts.logger.Warn("multicast group not joined on the requested interface",
+		"group", c.Address().String(),
+		"interface", ifi.Name,
+		"interface_index", ifi.Index,
+		"joined_indexes", indexes)
+}
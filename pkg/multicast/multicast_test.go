@@ -1,10 +1,27 @@
 package multicast
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/ipv4"
 )
 
 func TestNewListener(t *testing.T) {
@@ -387,3 +404,4402 @@ func BenchmarkConsumerCreation(b *testing.B) {
 		consumer.Close()
 	}
 }
+
+// roundTripResult is the outcome of sending packets to a consumer over the
+// loopback interface.
+type roundTripResult struct {
+	sent     int
+	received int
+	elapsed  time.Duration
+}
+
+func (r roundTripResult) drops() int {
+	return r.sent - r.received
+}
+
+// rate returns the received packets per second.
+func (r roundTripResult) rate() float64 {
+	return float64(r.received) / r.elapsed.Seconds()
+}
+
+// loopbackRoundTrip sends n packets of size bytes to a consumer of addr on
+// the loopback interface, through the full read loop and callback path, and
+// waits until all of them arrived or reception stalled. It returns false if
+// the consumer can't be created, e.g. without the required privileges.
+func loopbackRoundTrip(tb testing.TB, addr *net.UDPAddr, n, size int, opts ...ConsumerOption) (roundTripResult, bool) {
+	tb.Helper()
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	var received atomic.Int64
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		received.Add(1)
+	}, opts...)
+	if err != nil {
+		tb.Logf("failed to create consumer (may require privileges): %v", err)
+		return roundTripResult{}, false
+	}
+	defer consumer.Close()
+
+	sender := newLoopbackSender(tb)
+	defer sender.Close()
+
+	payload := make([]byte, size)
+	start := time.Now()
+
+	for i := 0; i < n; i++ {
+		if _, err := sender.WriteTo(payload, nil, addr); err != nil {
+			tb.Fatalf("failed to send packet: %v", err)
+		}
+	}
+
+	// Wait for the stragglers, giving up once nothing arrives anymore
+	last := received.Load()
+	for last < int64(n) {
+		time.Sleep(20 * time.Millisecond)
+
+		current := received.Load()
+		if current == last {
+			break
+		}
+
+		last = current
+	}
+
+	return roundTripResult{
+		sent:     n,
+		received: int(received.Load()),
+		elapsed:  time.Since(start),
+	}, true
+}
+
+func newLoopbackSender(t testing.TB) *ipv4.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to open sender socket: %v", err)
+	}
+
+	pc := ipv4.NewPacketConn(conn)
+
+	if err := pc.SetMulticastInterface(&net.Interface{Index: 1, Name: "lo"}); err != nil {
+		_ = pc.Close()
+		t.Fatalf("failed to set multicast interface: %v", err)
+	}
+
+	if err := pc.SetMulticastLoopback(true); err != nil {
+		_ = pc.Close()
+		t.Fatalf("failed to enable multicast loopback: %v", err)
+	}
+
+	return pc
+}
+
+func sendLoopback(t testing.TB, addr *net.UDPAddr, payloads ...[]byte) {
+	t.Helper()
+
+	pc := newLoopbackSender(t)
+	defer pc.Close()
+
+	for _, payload := range payloads {
+		if _, err := pc.WriteTo(payload, nil, addr); err != nil {
+			t.Fatalf("failed to send packet: %v", err)
+		}
+	}
+}
+
+func TestConsumerFanout(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12360")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var count int
+	var mu sync.Mutex
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	}, WithFanout(4))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	const packets = 50
+
+	for i := 0; i < packets; i++ {
+		sendLoopback(t, addr, []byte{byte(i)})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Each datagram must reach exactly one of the fanout sockets
+	if count != packets {
+		t.Fatalf("expected %d packets, got %d", packets, count)
+	}
+}
+
+func TestConsumerInvalidFanout(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12361")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	if _, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {}, WithFanout(0)); err == nil {
+		t.Fatal("expected error for fanout of 0")
+	}
+}
+
+func TestMaxMemberships(t *testing.T) {
+	n, err := MaxMemberships()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("membership limit not available on this platform")
+	}
+
+	if err != nil {
+		t.Fatalf("failed to read membership limit: %v", err)
+	}
+
+	if n <= 0 {
+		t.Fatalf("expected positive membership limit, got %d", n)
+	}
+}
+
+func TestJoinErrorMembershipLimit(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12362")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	c := &Consumer{}
+	c.addr.Store(addr)
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+
+	err = c.joinError(ifi, &os.SyscallError{Syscall: "setsockopt", Err: syscall.ENOBUFS})
+	if !errors.Is(err, ErrMembershipLimit) {
+		t.Fatalf("expected ErrMembershipLimit, got %v", err)
+	}
+
+	if !errors.Is(err, syscall.ENOBUFS) {
+		t.Fatalf("expected wrapped ENOBUFS, got %v", err)
+	}
+
+	err = c.joinError(ifi, syscall.EINVAL)
+	if errors.Is(err, ErrMembershipLimit) {
+		t.Fatalf("unexpected ErrMembershipLimit for EINVAL: %v", err)
+	}
+}
+
+func TestNewConsumerByLocalIP(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12363")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	_, err = NewConsumerByLocalIP(addr, []net.IP{net.ParseIP("192.0.2.254")}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err == nil {
+		t.Fatal("expected error for unknown local IP")
+	}
+
+	consumer, err := NewConsumerByLocalIP(addr, []net.IP{net.IPv4(127, 0, 0, 1)}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if len(consumer.Interfaces()) != 1 || consumer.Interfaces()[0].Flags&net.FlagLoopback == 0 {
+		t.Fatalf("expected the loopback interface, got %v", consumer.Interfaces())
+	}
+}
+
+func TestConsumerBindDevice(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12364")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	_, err = NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithBindDevice("does-not-exist0"))
+	if err == nil {
+		t.Fatal("expected error when binding to a missing device")
+	}
+}
+
+func TestConsumerInject(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12365")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+
+	var received [][]byte
+	var mu sync.Mutex
+
+	// No interfaces, so no sockets are opened
+	consumer, err := NewConsumer(addr, nil, func(gotIfi *net.Interface, gotSrc net.Addr, payload []byte) {
+		if gotIfi != ifi || gotSrc != src {
+			t.Errorf("unexpected packet metadata: %v %v", gotIfi, gotSrc)
+		}
+
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	consumer.Inject(ifi, src, []byte("hello"))
+	consumer.Close()
+	consumer.Inject(ifi, src, []byte("dropped"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 1 || string(received[0]) != "hello" {
+		t.Fatalf("expected one injected packet, got %q", received)
+	}
+}
+
+func TestConsumerStats(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12366")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"), []byte("defg"))
+	consumer.Inject(loopback, &net.UDPAddr{}, []byte("hi"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	stats := consumer.Stats()
+	if stats.Packets != 3 || stats.Bytes != 9 {
+		t.Fatalf("expected 3 packets and 9 bytes, got %+v", stats)
+	}
+
+	ifaceStats := consumer.InterfaceStats()
+	if len(ifaceStats) != 1 || ifaceStats[0].Interface != loopback || ifaceStats[0].Packets != 3 {
+		t.Fatalf("unexpected interface stats: %+v", ifaceStats)
+	}
+
+	if !ifaceStats[0].Joined || ifaceStats[0].LastError != nil || ifaceStats[0].LastPacket.IsZero() {
+		t.Fatalf("unexpected interface state: %+v", ifaceStats[0])
+	}
+
+	consumer.Close()
+
+	if consumer.InterfaceStats()[0].Joined {
+		t.Fatal("interface should not be joined after close")
+	}
+}
+
+func TestConsumerGroupCallback(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12367")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	dsts := make(chan *net.UDPAddr, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil,
+		WithGroupCallback(func(ifi *net.Interface, _ net.Addr, dst *net.UDPAddr, payload []byte) {
+			dsts <- dst
+		}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case dst := <-dsts:
+		if !dst.IP.Equal(addr.IP) || dst.Port != addr.Port {
+			t.Fatalf("expected destination %s, got %s", addr, dst)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+}
+
+func TestBindWithRetry(t *testing.T) {
+	c := &Consumer{opts: defaultConsumerOptions()}
+
+	if err := WithBindRetry(2, time.Millisecond)(&c.opts); err != nil {
+		t.Fatalf("failed to apply option: %v", err)
+	}
+
+	attempts := 0
+	err := c.bindWithRetry(func() error {
+		attempts++
+		return syscall.EADDRINUSE
+	})
+
+	if !errors.Is(err, ErrBindRetriesExhausted) || !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected exhausted retries wrapping EADDRINUSE, got %v", err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	err = c.bindWithRetry(func() error {
+		attempts++
+		if attempts < 2 {
+			return syscall.EADDRINUSE
+		}
+		return nil
+	})
+
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected success on second attempt, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestConsumerSerialDelivery(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12368")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var inFlight, maxInFlight, count atomic.Int32
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		n := inFlight.Add(1)
+		if n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+
+		time.Sleep(time.Millisecond)
+
+		inFlight.Add(-1)
+		count.Add(1)
+	}, WithSerialDelivery())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func(index int) {
+			defer wg.Done()
+
+			ifi := &net.Interface{Index: index + 1}
+			for j := 0; j < 5; j++ {
+				consumer.Inject(ifi, &net.UDPAddr{}, []byte{byte(j)})
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for count.Load() < 20 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if count.Load() != 20 {
+		t.Fatalf("expected 20 packets, got %d", count.Load())
+	}
+
+	if maxInFlight.Load() != 1 {
+		t.Fatalf("expected serial callback invocation, got %d concurrent calls", maxInFlight.Load())
+	}
+}
+
+func TestRangeConsumerFilter(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("239.1.0.0/16")
+
+	rc := &RangeConsumer{prefix: prefix, port: 5000}
+
+	raw, err := rc.filter()
+	if err != nil {
+		t.Fatalf("failed to assemble filter: %v", err)
+	}
+
+	insns, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatal("failed to disassemble filter")
+	}
+
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("failed to create BPF VM: %v", err)
+	}
+
+	datagram := func(dst net.IP, port uint16, fragOffset uint16) []byte {
+		b := make([]byte, 20+udpHeaderLen+3)
+		b[0] = 0x45
+		b[9] = ipProtocolUDP
+		binary.BigEndian.PutUint16(b[6:8], fragOffset)
+		copy(b[12:16], net.IPv4(192, 0, 2, 1).To4())
+		copy(b[16:20], dst.To4())
+		binary.BigEndian.PutUint16(b[20:22], 4000)
+		binary.BigEndian.PutUint16(b[22:24], port)
+		binary.BigEndian.PutUint16(b[24:26], udpHeaderLen+3)
+		copy(b[28:], "abc")
+		return b
+	}
+
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+		match  bool
+	}{
+		{"in range", datagram(net.IPv4(239, 1, 2, 3), 5000, 0), true},
+		{"out of range", datagram(net.IPv4(239, 2, 2, 3), 5000, 0), false},
+		{"wrong port", datagram(net.IPv4(239, 1, 2, 3), 5001, 0), false},
+		{"fragment", datagram(net.IPv4(239, 1, 2, 3), 5000, 10), false},
+	} {
+		n, err := vm.Run(tc.packet)
+		if err != nil {
+			t.Fatalf("%s: failed to run filter: %v", tc.name, err)
+		}
+
+		if (n > 0) != tc.match {
+			t.Fatalf("%s: expected match %v, got %d", tc.name, tc.match, n)
+		}
+
+		if !tc.match {
+			continue
+		}
+
+		src, dst, payload, ok := parseUDPv4(tc.packet)
+		if !ok || src.Port != 4000 || dst.Port != 5000 || !dst.IP.Equal(net.IPv4(239, 1, 2, 3)) || string(payload) != "abc" {
+			t.Fatalf("%s: unexpected parse result %v %v %q %v", tc.name, src, dst, payload, ok)
+		}
+	}
+}
+
+func TestRangeConsumer(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	_, prefix, _ := net.ParseCIDR("224.1.2.0/24")
+	dsts := make(chan *net.UDPAddr, 10)
+
+	rc, err := NewRangeConsumer(prefix, 12369, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, dst *net.UDPAddr, payload []byte) {
+		dsts <- dst
+	})
+	if err != nil {
+		t.Logf("failed to create range consumer (expected on some systems): %v", err)
+		return
+	}
+	defer rc.Close()
+
+	sendLoopback(t, &net.UDPAddr{IP: net.IPv4(224, 1, 2, 5), Port: 12369}, []byte("abc"))
+	sendLoopback(t, &net.UDPAddr{IP: net.IPv4(224, 1, 3, 5), Port: 12369}, []byte("abc"))
+
+	select {
+	case dst := <-dsts:
+		if !dst.IP.Equal(net.IPv4(224, 1, 2, 5)) {
+			t.Fatalf("unexpected destination %s", dst)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	select {
+	case dst := <-dsts:
+		t.Fatalf("unexpected packet to %s", dst)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRangeConsumerInvalidPrefix(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("10.0.0.0/8")
+
+	if _, err := NewRangeConsumer(prefix, 0, nil, nil); err == nil {
+		t.Fatal("expected error for non-multicast prefix")
+	}
+}
+
+func TestConsumerSetInterfaces(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12370")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.SetInterfaces([]*net.Interface{loopback}); err != nil {
+		t.Logf("failed to set interfaces (expected on some systems): %v", err)
+		return
+	}
+
+	if len(consumer.Interfaces()) != 1 || !consumer.InterfaceStats()[0].Joined {
+		t.Fatalf("expected joined loopback interface, got %+v", consumer.InterfaceStats())
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(100 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Fatalf("expected 1 packet, got %d", count.Load())
+	}
+
+	if err := consumer.SetInterfaces(nil); err != nil {
+		t.Fatalf("failed to clear interfaces: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(100 * time.Millisecond)
+
+	if count.Load() != 1 || len(consumer.InterfaceStats()) != 0 {
+		t.Fatalf("expected no more packets after removing interfaces, got %d", count.Load())
+	}
+
+	consumer.Close()
+
+	if err := consumer.SetInterfaces([]*net.Interface{loopback}); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestConsumerTrustSocketFiltering(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12371")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	}, WithTrustSocketFiltering())
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"), []byte("def"))
+	time.Sleep(100 * time.Millisecond)
+
+	if count.Load() != 2 {
+		t.Fatalf("expected 2 packets, got %d", count.Load())
+	}
+}
+
+func TestListenerStats(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	listener := NewListener([]*net.Interface{loopback})
+	defer listener.Close()
+
+	addr1, _ := net.ResolveUDPAddr("udp", "224.1.1.8:12372")
+	addr2, _ := net.ResolveUDPAddr("udp", "224.1.1.8:12373")
+
+	consumer1, err1 := listener.AddConsumer(addr1, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	consumer2, err2 := listener.AddConsumer(addr2, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+
+	if err1 != nil || err2 != nil {
+		t.Logf("failed to add consumers (expected on some systems): %v, %v", err1, err2)
+		return
+	}
+
+	consumer1.Inject(loopback, &net.UDPAddr{}, []byte("abc"))
+	consumer2.Inject(loopback, &net.UDPAddr{}, []byte("de"))
+	consumer2.Close()
+
+	stats := listener.Stats()
+	if stats.Consumers != 1 || stats.Packets != 2 || stats.Bytes != 5 || stats.Interfaces != 1 {
+		t.Fatalf("unexpected listener stats: %+v", stats)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	for _, tc := range []struct {
+		mtus []int
+		want int
+	}{
+		{nil, maxMTU},
+		{[]int{576}, maxMTU},
+		{[]int{1500, 9000}, 9000},
+		{[]int{65536}, maxDatagram},
+	} {
+		ifis := make([]*net.Interface, 0, len(tc.mtus))
+		for i, mtu := range tc.mtus {
+			ifis = append(ifis, &net.Interface{Index: i + 1, MTU: mtu})
+		}
+
+		if got := readBufferSize(ifis); got != tc.want {
+			t.Errorf("readBufferSize(%v) = %d, want %d", tc.mtus, got, tc.want)
+		}
+	}
+}
+
+func TestConsumerTruncation(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   maxMTU,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12374")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	sizes := make(chan int, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		sizes <- len(payload)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, make([]byte, maxMTU+100))
+
+	select {
+	case size := <-sizes:
+		if size != maxMTU {
+			t.Fatalf("expected truncated payload of %d bytes, got %d", maxMTU, size)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	stats := consumer.Stats()
+	if stats.Truncated != 1 {
+		t.Fatalf("expected 1 truncated packet, got %d", stats.Truncated)
+	}
+
+	if runtime.GOOS == "linux" && stats.TruncatedBytes != 100 {
+		t.Fatalf("expected 100 truncated bytes, got %d", stats.TruncatedBytes)
+	}
+}
+
+func TestConsumerWaitForFirstPacket(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12375")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := consumer.WaitForFirstPacket(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	go consumer.Inject(&net.Interface{Index: 1}, &net.UDPAddr{}, []byte("abc"))
+
+	if err := consumer.WaitForFirstPacket(context.Background()); err != nil {
+		t.Fatalf("expected first packet, got %v", err)
+	}
+}
+
+func TestConsumerBindAddress(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12376")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	cb := func(ifi *net.Interface, _ net.Addr, payload []byte) {}
+
+	if _, err := NewConsumer(addr, nil, cb, WithBindAddress(net.IPv4(127, 0, 0, 1))); err == nil {
+		t.Fatal("expected error for unicast bind address")
+	}
+
+	if _, err := NewConsumer(addr, nil, cb, WithBindAddress(net.IPv4zero), WithTrustSocketFiltering()); err == nil {
+		t.Fatal("expected error when trusting socket filtering with a wildcard bind")
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	}, WithBindAddress(net.IPv4zero))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"))
+	// Same port, different group: must be filtered out
+	sendLoopback(t, &net.UDPAddr{IP: net.IPv4(224, 1, 1, 9), Port: addr.Port}, []byte("abc"))
+	time.Sleep(100 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Fatalf("expected 1 packet, got %d", count.Load())
+	}
+}
+
+func TestConsumerTap(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12377")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {}, WithTap(&buf))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	consumer.Inject(&net.Interface{Index: 3}, src, []byte("abc"))
+	consumer.Inject(&net.Interface{Index: 3}, src, []byte("defg"))
+
+	for _, want := range []string{"abc", "defg"} {
+		record, err := ReadTapRecord(&buf)
+		if err != nil {
+			t.Fatalf("failed to read tap record: %v", err)
+		}
+
+		if record.InterfaceIndex != 3 || !record.Src.IP.Equal(src.IP) || record.Src.Port != src.Port ||
+			!record.Dst.IP.Equal(addr.IP) || record.Dst.Port != addr.Port || string(record.Payload) != want {
+			t.Fatalf("unexpected tap record: %+v", record)
+		}
+	}
+
+	if _, err := ReadTapRecord(&buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestConsumerPcapTap(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12377}
+
+	var buf bytes.Buffer
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {}, WithPcapTap(&buf))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	header := buf.Next(pcapFileHeaderLen)
+	if len(header) != pcapFileHeaderLen || binary.LittleEndian.Uint32(header[0:4]) != pcapMagicNanoseconds ||
+		binary.LittleEndian.Uint32(header[20:24]) != pcapLinkTypeRaw {
+		t.Fatalf("unexpected pcap header %x", header)
+	}
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	consumer.Inject(&net.Interface{Index: 3}, src, []byte("abc"))
+
+	record := buf.Next(pcapRecordHeaderLen)
+	length := int(binary.LittleEndian.Uint32(record[8:12]))
+
+	if length != ipv4HeaderLen+udpHeaderLen+3 || binary.LittleEndian.Uint32(record[12:16]) != uint32(length) {
+		t.Fatalf("unexpected pcap record header %x", record)
+	}
+
+	ip := buf.Next(length)
+
+	if ip[0] != 0x45 || ip[9] != ipProtocolUDP || ipv4Checksum(ip[:ipv4HeaderLen]) != 0 {
+		t.Fatalf("unexpected IPv4 header %x", ip[:ipv4HeaderLen])
+	}
+
+	if !net.IP(ip[12:16]).Equal(src.IP) || !net.IP(ip[16:20]).Equal(addr.IP) {
+		t.Fatalf("unexpected addresses %s and %s", net.IP(ip[12:16]), net.IP(ip[16:20]))
+	}
+
+	udp := ip[ipv4HeaderLen:]
+
+	if binary.BigEndian.Uint16(udp[0:2]) != 4000 || binary.BigEndian.Uint16(udp[2:4]) != 12377 ||
+		binary.BigEndian.Uint16(udp[4:6]) != udpHeaderLen+3 || string(udp[udpHeaderLen:]) != "abc" {
+		t.Fatalf("unexpected UDP datagram %x", udp)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected a single record, got %d more bytes", buf.Len())
+	}
+}
+
+func TestConsumerPcapTapFailedStart(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12443}
+
+	var buf bytes.Buffer
+
+	if _, err := NewConsumer(addr, nil, nil, WithPcapTap(&buf), WithSourceOrdering()); err == nil {
+		t.Fatal("expected invalid options to fail")
+	}
+
+	// Joining on no interface fails once the sockets were set up
+	noMulticast := &net.Interface{Index: 1, MTU: 65536, Name: "lo", Flags: net.FlagUp | net.FlagLoopback}
+
+	if _, err := NewConsumer(addr, []*net.Interface{noMulticast}, nil, WithPcapTap(&buf), WithRequireJoin()); err == nil {
+		t.Fatal("expected the consumer to fail without a joined interface")
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected no pcap header for consumers that failed to start, got %d bytes", buf.Len())
+	}
+}
+
+func TestConsumerPcapTapCapture(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12377}
+	clock := newFakeClock()
+
+	var buf bytes.Buffer
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithPcapTap(&buf), WithMaxCaptureBytes(2), WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	buf.Next(pcapFileHeaderLen)
+
+	consumer.Inject(&net.Interface{Index: 3}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}, []byte("abcd"))
+
+	record := buf.Next(pcapRecordHeaderLen)
+
+	sec, nsec := binary.LittleEndian.Uint32(record[0:4]), binary.LittleEndian.Uint32(record[4:8])
+	if int64(sec) != clock.Now().Unix() || nsec != 0 {
+		t.Fatalf("expected the consumer clock's time, got %d.%09d", sec, nsec)
+	}
+
+	// Only the captured bytes are included, the original length is kept
+	length := int(binary.LittleEndian.Uint32(record[8:12]))
+	if length != ipv4HeaderLen+udpHeaderLen+2 || binary.LittleEndian.Uint32(record[12:16]) != ipv4HeaderLen+udpHeaderLen+4 {
+		t.Fatalf("unexpected pcap record header %x", record)
+	}
+
+	ip := buf.Next(length)
+
+	if binary.BigEndian.Uint16(ip[2:4]) != ipv4HeaderLen+udpHeaderLen+4 ||
+		binary.BigEndian.Uint16(ip[ipv4HeaderLen+4:]) != udpHeaderLen+4 {
+		t.Fatalf("expected the original lengths in the headers, got %x", ip)
+	}
+}
+
+func TestConsumerRejoinOnSilence(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12378")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	}, WithRejoinOnSilence(20*time.Millisecond))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if consumer.Stats().Rejoins == 0 {
+		t.Fatal("expected the consumer to rejoin after silence")
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 1 || !consumer.InterfaceStats()[0].Joined {
+		t.Fatalf("expected reception after rejoin, got %d packets", count.Load())
+	}
+
+	if err := consumer.Rejoin(); err != nil {
+		t.Fatalf("failed to rejoin: %v", err)
+	}
+}
+
+func TestConsumerPacketCallback(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   maxMTU,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12379")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	packets := make(chan *Packet, 2)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithPacketCallback(func(pkt *Packet) {
+		packets <- pkt
+	}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"), make([]byte, maxMTU+1))
+
+	for _, truncated := range []bool{false, true} {
+		select {
+		case pkt := <-packets:
+			if pkt.Interface != loopback || !pkt.Dst.IP.Equal(addr.IP) || pkt.Dst.Port != addr.Port || pkt.Src == nil {
+				t.Fatalf("unexpected packet metadata: %+v", pkt)
+			}
+
+			if pkt.Truncated != truncated {
+				t.Fatalf("expected truncated %v, got %v", truncated, pkt.Truncated)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for packet")
+		}
+	}
+}
+
+func TestWellKnownGroups(t *testing.T) {
+	for _, g := range WellKnownGroups() {
+		if !g.IP.IsMulticast() {
+			t.Fatalf("group %s has non-multicast address %s", g.Name, g.IP)
+		}
+
+		if g.Name == "" || g.Description == "" {
+			t.Fatalf("group %s is missing a name or description", g.IP)
+		}
+	}
+
+	if !IsLinkLocalGroup(AllSystemsGroup) || !IsLinkLocalGroup(MDNSGroup) {
+		t.Fatal("expected 224.0.0.0/24 groups to be link-local")
+	}
+
+	if IsLinkLocalGroup(SSDPGroup) || IsLinkLocalGroup(NTPGroup) {
+		t.Fatal("expected groups outside 224.0.0.0/24 not to be link-local")
+	}
+}
+
+func TestConsumerSuspendDelivery(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12380")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	consumer.SuspendDelivery()
+
+	sendLoopback(t, addr, []byte("a"), []byte("b"), []byte("c"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 0 {
+		t.Fatalf("expected no packets while suspended, got %d", count.Load())
+	}
+
+	consumer.ResumeDelivery()
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 3 {
+		t.Fatalf("expected 3 buffered packets after resume, got %d", count.Load())
+	}
+}
+
+func TestConsumerSuspendDeliveryInject(t *testing.T) {
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12439}
+
+	received := make(chan string, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{ifi}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		received <- string(payload)
+	}, WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	consumer.SuspendDelivery()
+
+	injected := make(chan struct{})
+
+	go func() {
+		defer close(injected)
+
+		consumer.Inject(ifi, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}, []byte("abc"))
+	}()
+
+	select {
+	case payload := <-received:
+		t.Fatalf("expected no delivery while suspended, got %q", payload)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	consumer.ResumeDelivery()
+
+	select {
+	case payload := <-received:
+		if payload != "abc" {
+			t.Fatalf("expected the injected packet, got %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the injected packet after resuming")
+	}
+
+	<-injected
+
+	// Closing releases a blocked Inject
+	consumer.SuspendDelivery()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		consumer.Close()
+	}()
+
+	consumer.Inject(ifi, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}, []byte("def"))
+
+	select {
+	case payload := <-received:
+		t.Fatalf("expected no delivery after close, got %q", payload)
+	default:
+	}
+}
+func TestConsumerECN(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ECN is only supported on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12381")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	ecns := make(chan ECN, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithECN(), WithPacketCallback(func(pkt *Packet) {
+		if pkt.HasTOS {
+			ecns <- pkt.ECN()
+		}
+	}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	pc := newLoopbackSender(t)
+	defer pc.Close()
+
+	if err := pc.SetTOS(0xb8 | int(ECNCE)); err != nil {
+		t.Fatalf("failed to set TOS: %v", err)
+	}
+
+	if _, err := pc.WriteTo([]byte("abc"), nil, addr); err != nil {
+		t.Fatalf("failed to send packet: %v", err)
+	}
+
+	select {
+	case ecn := <-ecns:
+		if ecn != ECNCE {
+			t.Fatalf("expected %s, got %s", ECNCE, ecn)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	if consumer.Stats().CongestionExperienced != 1 {
+		t.Fatalf("expected 1 CE marked packet, got %d", consumer.Stats().CongestionExperienced)
+	}
+}
+
+func TestConsumerMaxQueuedBytes(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12382")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	if _, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {}, WithMaxQueuedBytes(10)); err == nil {
+		t.Fatal("expected error without a queued delivery mode")
+	}
+
+	block := make(chan struct{})
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		<-block
+		count.Add(1)
+	}, WithSerialDelivery(), WithMaxQueuedBytes(10))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	loopback := &net.Interface{Index: 1, Name: "lo"}
+
+	// The first packet is picked up by the blocked callback, the next two
+	// fill the queue, and the rest exceed the limit.
+	consumer.Inject(loopback, &net.UDPAddr{}, make([]byte, 5))
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		consumer.Inject(loopback, &net.UDPAddr{}, make([]byte, 5))
+	}
+
+	close(block)
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 3 {
+		t.Fatalf("expected 3 delivered packets, got %d", count.Load())
+	}
+}
+
+func TestConsumerCloseAndRemove(t *testing.T) {
+	listener := NewListener(nil)
+	defer listener.Close()
+
+	addr, err := net.ResolveUDPAddr("udp", "224.1.1.8:12383")
+	if err != nil {
+		t.Fatalf("failed to resolve UDP address: %v", err)
+	}
+
+	consumer, err := listener.AddConsumer(addr, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Fatalf("failed to add consumer: %v", err)
+	}
+
+	consumer.CloseAndRemove()
+
+	if len(listener.Consumers()) != 0 {
+		t.Fatalf("expected 0 consumers after CloseAndRemove, got %d", len(listener.Consumers()))
+	}
+
+	if !consumer.isClosed() {
+		t.Fatal("consumer should be closed")
+	}
+
+	// Safe to call again, and on consumers without a listener
+	consumer.CloseAndRemove()
+
+	standalone, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	standalone.CloseAndRemove()
+}
+
+func TestListenerSharedSockets(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	listener := NewListener([]*net.Interface{loopback}, WithSharedSockets())
+	defer listener.Close()
+
+	addrA := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 9), Port: 12384}
+	addrB := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 10), Port: 12384}
+
+	var countA, countB atomic.Int32
+
+	consumerA, err := listener.AddConsumer(addrA, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		countA.Add(1)
+	})
+	if err != nil {
+		t.Logf("failed to add consumer (may require privileges): %v", err)
+		return
+	}
+
+	consumerB, err := listener.AddConsumer(addrB, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		countB.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("failed to add second consumer: %v", err)
+	}
+
+	if len(listener.pool.sockets) != 1 {
+		t.Fatalf("expected 1 shared socket, got %d", len(listener.pool.sockets))
+	}
+
+	sendLoopback(t, addrA, []byte("a1"), []byte("a2"))
+	sendLoopback(t, addrB, []byte("b1"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if countA.Load() != 2 || countB.Load() != 1 {
+		t.Fatalf("expected 2 and 1 packets, got %d and %d", countA.Load(), countB.Load())
+	}
+
+	consumerA.CloseAndRemove()
+
+	sendLoopback(t, addrB, []byte("b2"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if countB.Load() != 2 {
+		t.Fatalf("expected 2 packets after closing the other consumer, got %d", countB.Load())
+	}
+
+	consumerB.CloseAndRemove()
+
+	if len(listener.pool.sockets) != 0 {
+		t.Fatalf("expected shared socket to be closed, got %d", len(listener.pool.sockets))
+	}
+
+	if _, err := listener.AddConsumer(addrA, nil, WithFanout(2)); err == nil {
+		t.Fatal("expected error combining shared sockets with fanout")
+	}
+}
+
+func TestConsumerDrainOnClose(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12385}
+	loopback := &net.Interface{Index: 1, Name: "lo"}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		time.Sleep(5 * time.Millisecond)
+		count.Add(1)
+	}, WithSerialDelivery(), WithDrainOnClose())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	for range 10 {
+		consumer.Inject(loopback, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, []byte("x"))
+	}
+
+	consumer.Close()
+
+	if count.Load() != 10 {
+		t.Fatalf("expected all 10 queued packets to be delivered, got %d", count.Load())
+	}
+
+	if _, err := NewConsumer(addr, nil, nil, WithDrainOnClose()); err == nil {
+		t.Fatal("expected error draining without a queued delivery mode")
+	}
+}
+
+func TestLoopbackRoundTrip(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12386}
+
+	result, ok := loopbackRoundTrip(t, addr, 50, 64)
+	if !ok {
+		return
+	}
+
+	if result.received != result.sent {
+		t.Fatalf("expected %d packets, got %d", result.sent, result.received)
+	}
+}
+
+func BenchmarkConsumerLoopbackRoundTrip(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []ConsumerOption
+	}{
+		{name: "direct"},
+		{name: "serial", opts: []ConsumerOption{WithSerialDelivery()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12387}
+
+			result, ok := loopbackRoundTrip(b, addr, b.N, 512, bc.opts...)
+			if !ok {
+				return
+			}
+
+			b.ReportMetric(result.rate(), "pkts/s")
+			b.ReportMetric(float64(result.drops()), "drops")
+		})
+	}
+}
+
+func TestPresetConsumers(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	mdns, err := NewMDNSConsumer([]*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer mdns.Close()
+
+	if addr := mdns.Address(); !addr.IP.Equal(MDNSGroup) || addr.Port != 5353 {
+		t.Fatalf("unexpected mDNS address %s", addr)
+	}
+
+	ssdp, err := NewSSDPConsumer([]*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create SSDP consumer: %v", err)
+	}
+	defer ssdp.Close()
+
+	if addr := ssdp.Address(); !addr.IP.Equal(SSDPGroup) || addr.Port != 1900 {
+		t.Fatalf("unexpected SSDP address %s", addr)
+	}
+
+	// The kernel caps the size, so only check that it was set
+	size, err := ssdp.ifaces[1].sockets[0].receiveBuffer()
+	if err != nil {
+		t.Fatalf("failed to read SO_RCVBUF: %v", err)
+	}
+
+	if size == 0 {
+		t.Fatal("expected a receive buffer size")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.String()
+}
+
+func TestConsumerCallbackDeadline(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12388}
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		if string(payload) == "slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}, WithCallbackDeadline(10*time.Millisecond), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	ifi := &net.Interface{Index: 3, Name: "eth3"}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+
+	consumer.Inject(ifi, src, []byte("fast"))
+
+	if logs.String() != "" {
+		t.Fatalf("expected no warning for a fast callback, got %q", logs.String())
+	}
+
+	consumer.Inject(ifi, src, []byte("slow"))
+
+	if out := logs.String(); !strings.Contains(out, "exceeded deadline") || !strings.Contains(out, "interface=eth3") ||
+		!strings.Contains(out, "returned after exceeding deadline") {
+		t.Fatalf("expected deadline warnings, got %q", out)
+	}
+}
+
+func TestConsumerTimestamping(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel timestamps on loopback are only checked on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12389}
+
+	packets := make(chan *Packet, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithTimestamping(), WithPacketCallback(func(pkt *Packet) {
+		select {
+		case packets <- pkt:
+		default:
+		}
+	}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	before := time.Now()
+	deadline := time.After(time.Second)
+
+	// The kernel enables timestamping asynchronously, so the first packets
+	// may arrive without one and are timestamped in userspace instead
+	for {
+		sendLoopback(t, addr, []byte("abc"))
+
+		select {
+		case pkt := <-packets:
+			if pkt.Timestamp.IsZero() {
+				t.Fatal("expected every packet to be timestamped")
+			}
+
+			if pkt.TimestampSource == TimestampUserspace {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			// Loopback has no hardware clock, so the software timestamp is used
+			if pkt.HardwareTimestamp || pkt.TimestampSource != TimestampKernel {
+				t.Fatalf("unexpected %s timestamp on loopback", pkt.TimestampSource)
+			}
+
+			if pkt.Timestamp.Before(before) || pkt.Timestamp.After(time.Now()) {
+				t.Fatalf("unexpected timestamp %s", pkt.Timestamp)
+			}
+
+			return
+		case <-deadline:
+			t.Fatal("timed out waiting for a timestamped packet")
+		}
+	}
+}
+
+func TestConsumerSequenceGaps(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12390}
+
+	type gap struct {
+		src           string
+		expected, got uint64
+	}
+
+	var gaps []gap
+
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+
+	// Lazily started, so that injected packets are counted on ifi
+	consumer, err := NewConsumer(addr, []*net.Interface{ifi}, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithSequenceGaps(1, 2, func(src net.Addr, expected, got uint64) {
+			gaps = append(gaps, gap{src.String(), expected, got})
+		}), WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+	srcA := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	srcB := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 4000}
+
+	packet := func(seq uint16) []byte {
+		return []byte{0xff, byte(seq >> 8), byte(seq), 0xff}
+	}
+
+	consumer.Inject(ifi, srcA, packet(65534))
+	consumer.Inject(ifi, srcB, packet(100))
+	consumer.Inject(ifi, srcA, packet(65535))
+	consumer.Inject(ifi, srcA, packet(0))
+	consumer.Inject(ifi, srcB, packet(101))
+	consumer.Inject(ifi, srcA, packet(2))
+	consumer.Inject(ifi, srcA, []byte{0xff})
+
+	// Late across the wrap around, and a duplicate
+	consumer.Inject(ifi, srcA, packet(65535))
+	consumer.Inject(ifi, srcB, packet(101))
+
+	if len(gaps) != 1 || gaps[0] != (gap{srcA.String(), 1, 2}) {
+		t.Fatalf("unexpected gaps %+v", gaps)
+	}
+
+	if stats := consumer.Stats(); stats.SequenceGaps != 1 || stats.Reordered != 2 {
+		t.Fatalf("expected 1 gap and 2 reordered packets, got %d and %d", stats.SequenceGaps, stats.Reordered)
+	}
+
+	if _, err := NewConsumer(addr, nil, nil, WithSequenceGaps(0, 3, nil)); err == nil {
+		t.Fatal("expected error for a 3 byte sequence number")
+	}
+}
+
+func TestParseIGMPMemberships(t *testing.T) {
+	hex := func(ip net.IP) string {
+		return fmt.Sprintf("%08X", binary.NativeEndian.Uint32(ip.To4()))
+	}
+
+	group := net.IPv4(224, 1, 1, 11)
+
+	input := "Idx\tDevice    : Count Querier\tGroup    Users Timer\tReporter\n" +
+		"1\tlo        :     2      V3\n" +
+		"\t\t\t\t" + hex(AllSystemsGroup) + "     1 0:00000000\t\t0\n" +
+		"\t\t\t\t" + hex(group) + "     1 0:00000000\t\t0\n" +
+		"4\teth0      :     1      V3\n" +
+		"\t\t\t\t" + hex(AllSystemsGroup) + "     1 0:00000000\t\t0\n"
+
+	indexes, err := parseIGMPMemberships(strings.NewReader(input), group)
+	if err != nil {
+		t.Fatalf("failed to parse memberships: %v", err)
+	}
+
+	if len(indexes) != 1 || indexes[0] != 1 {
+		t.Fatalf("expected membership on interface 1, got %v", indexes)
+	}
+
+	indexes, err = parseIGMPMemberships(strings.NewReader(input), AllSystemsGroup)
+	if err != nil {
+		t.Fatalf("failed to parse memberships: %v", err)
+	}
+
+	if len(indexes) != 2 || indexes[0] != 1 || indexes[1] != 4 {
+		t.Fatalf("expected memberships on interfaces 1 and 4, got %v", indexes)
+	}
+}
+
+func TestConsumerJoinVerification(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 11), Port: 12391}
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithJoinVerification(), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if strings.Contains(logs.String(), "not joined") {
+		t.Fatalf("unexpected warning: %s", logs.String())
+	}
+}
+
+func TestConsumerReset(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12392}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {
+		count.Add(1)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+
+	sendLoopback(t, addr, []byte("before"))
+	time.Sleep(50 * time.Millisecond)
+
+	oldSocket := consumer.ifaces[1].sockets[0]
+
+	if err := consumer.Reset(); err != nil {
+		t.Fatalf("failed to reset consumer: %v", err)
+	}
+
+	if consumer.ifaces[1].sockets[0] == oldSocket {
+		t.Fatal("expected the socket to be reopened")
+	}
+
+	sendLoopback(t, addr, []byte("after"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 2 {
+		t.Fatalf("expected 2 packets, got %d", count.Load())
+	}
+
+	if stats := consumer.Stats(); stats.Packets != 2 {
+		t.Fatalf("expected stats to survive the reset, got %d packets", stats.Packets)
+	}
+
+	consumer.Close()
+
+	if err := consumer.Reset(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestListenerAddConsumerOn(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	// Without the multicast flag, no socket is opened on this one
+	other := &net.Interface{Index: 1000, Name: "other0"}
+
+	listener := NewListener([]*net.Interface{loopback, other})
+	defer listener.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12393}
+
+	consumer, err := listener.AddConsumerOn(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to add consumer (may require privileges): %v", err)
+		return
+	}
+
+	if ifis := consumer.Interfaces(); len(ifis) != 1 || ifis[0].Index != 1 {
+		t.Fatalf("expected the consumer to use only the loopback interface, got %v", ifis)
+	}
+
+	if len(listener.Consumers()) != 1 {
+		t.Fatalf("expected 1 consumer, got %d", len(listener.Consumers()))
+	}
+
+	_, err = listener.AddConsumerOn(addr, []*net.Interface{{Index: 2000, Name: "foreign0"}}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err == nil {
+		t.Fatal("expected error for an interface outside the listener's set")
+	}
+}
+
+func TestConsumerDestinationMismatches(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12394}
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithBindAddress(net.IPv4zero),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	// The wildcard bind also receives unicast datagrams on the port
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port})
+	if err != nil {
+		t.Fatalf("failed to open unicast socket: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatalf("failed to send packet: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	stats := consumer.Stats()
+	if stats.DestinationMismatches != 1 || stats.Packets != 0 {
+		t.Fatalf("expected 1 mismatch and no packets, got %+v", stats)
+	}
+
+	if !strings.Contains(logs.String(), "destination=127.0.0.1") {
+		t.Fatalf("expected the mismatch to be logged, got %q", logs.String())
+	}
+}
+
+func TestConsumerMulticastAll(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("IP_MULTICAST_ALL is only supported on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12445}
+	other := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 9), Port: addr.Port}
+
+	noop := func(*net.Interface, net.Addr, []byte) {}
+
+	// Another consumer on the same port joins a group of its own
+	otherConsumer, err := NewConsumer(other, []*net.Interface{loopback}, noop)
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer otherConsumer.Close()
+
+	// Bound to the wildcard, the sockets are only kept from the other
+	// group by the membership filter of IP_MULTICAST_ALL
+	own, err := NewConsumer(addr, []*net.Interface{loopback}, noop, WithBindAddress(net.IPv4zero))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer own.Close()
+
+	all, err := NewConsumer(addr, []*net.Interface{loopback}, noop, WithBindAddress(net.IPv4zero), WithMulticastAll())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer all.Close()
+
+	sendLoopback(t, other, []byte("abc"))
+	time.Sleep(100 * time.Millisecond)
+
+	if stats := own.Stats(); stats.DestinationMismatches != 0 {
+		t.Fatalf("expected the other group's datagram to stay off the socket, got %+v", stats)
+	}
+
+	if stats := all.Stats(); stats.DestinationMismatches != 1 {
+		t.Fatalf("expected WithMulticastAll to receive the other group's datagram, got %+v", stats)
+	}
+}
+
+func TestFindSubnetOverlaps(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0"}
+	eth1 := &net.Interface{Index: 3, Name: "eth1"}
+	eth2 := &net.Interface{Index: 4, Name: "eth2"}
+
+	ipNet := func(cidr string) *net.IPNet {
+		ip, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", cidr, err)
+		}
+
+		n.IP = ip
+
+		return n
+	}
+
+	addrs := map[int][]*net.IPNet{
+		eth0.Index: {ipNet("192.168.1.10/24"), ipNet("fe80::1/64")},
+		eth1.Index: {ipNet("192.168.1.11/24"), ipNet("fe80::2/64")},
+		eth2.Index: {ipNet("10.0.0.1/8")},
+	}
+
+	overlaps := findSubnetOverlaps([]*net.Interface{eth0, eth1, eth2}, addrs)
+	if len(overlaps) != 1 {
+		t.Fatalf("expected 1 overlap, got %d", len(overlaps))
+	}
+
+	if o := overlaps[0]; o.a != eth0 || o.b != eth1 || o.subnet.String() != "192.168.1.0/24" {
+		t.Fatalf("unexpected overlap %s/%s on %s", o.a.Name, o.b.Name, o.subnet)
+	}
+}
+
+func TestEthernetConsumerFilter(t *testing.T) {
+	addr := net.HardwareAddr{0x01, 0x0c, 0xcd, 0x01, 0x00, 0x01}
+
+	ec := &EthernetConsumer{addr: addr}
+
+	raw, err := ec.filter()
+	if err != nil {
+		t.Fatalf("failed to assemble filter: %v", err)
+	}
+
+	insns, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatal("failed to disassemble filter")
+	}
+
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("failed to create BPF VM: %v", err)
+	}
+
+	frame := func(dst net.HardwareAddr) []byte {
+		b := make([]byte, 60)
+		copy(b[0:6], dst)
+		copy(b[6:12], net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+		binary.BigEndian.PutUint16(b[12:14], 0x88b8)
+		return b
+	}
+
+	for _, tc := range []struct {
+		name  string
+		dst   net.HardwareAddr
+		match bool
+	}{
+		{"joined", addr, true},
+		{"other group", net.HardwareAddr{0x01, 0x0c, 0xcd, 0x01, 0x00, 0x02}, false},
+		{"other prefix", net.HardwareAddr{0x01, 0x0c, 0xcd, 0x02, 0x00, 0x01}, false},
+	} {
+		n, err := vm.Run(frame(tc.dst))
+		if err != nil {
+			t.Fatalf("%s: failed to run filter: %v", tc.name, err)
+		}
+
+		if (n > 0) != tc.match {
+			t.Fatalf("%s: expected match %v, got %d", tc.name, tc.match, n)
+		}
+	}
+
+	if _, err := NewEthernetConsumer(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, nil, nil); err == nil {
+		t.Fatal("expected error for a unicast MAC address")
+	}
+}
+
+func TestListenerMaxConsumers(t *testing.T) {
+	listener := NewListener(nil, WithMaxConsumers(2))
+	defer listener.Close()
+
+	cb := func(ifi *net.Interface, _ net.Addr, payload []byte) {}
+
+	first, err := listener.AddConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12395}, cb)
+	if err != nil {
+		t.Fatalf("failed to add consumer: %v", err)
+	}
+
+	if _, err := listener.AddConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 9), Port: 12395}, cb); err != nil {
+		t.Fatalf("failed to add consumer: %v", err)
+	}
+
+	_, err = listener.AddConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 10), Port: 12395}, cb)
+	if !errors.Is(err, ErrTooManyConsumers) {
+		t.Fatalf("expected ErrTooManyConsumers, got %v", err)
+	}
+
+	// Closed consumers no longer count
+	first.Close()
+
+	if _, err := listener.AddConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 10), Port: 12395}, cb); err != nil {
+		t.Fatalf("failed to add consumer after closing one: %v", err)
+	}
+}
+
+func TestParseReassemblyStats(t *testing.T) {
+	input := "Ip: Forwarding DefaultTTL InReceives ReasmTimeout ReasmReqds ReasmOKs ReasmFails FragOKs\n" +
+		"Ip: 2 64 52098 30 12 4 1 0\n" +
+		"Icmp: InMsgs InErrors\n" +
+		"Icmp: 0 0\n"
+
+	stats, err := parseReassemblyStats(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("failed to parse reassembly stats: %v", err)
+	}
+
+	if stats != (ReassemblyStats{Required: 12, OK: 4, Failed: 1, Timeout: 30}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if _, err := parseReassemblyStats(strings.NewReader("Icmp: InMsgs\nIcmp: 0\n")); err == nil {
+		t.Fatal("expected error without Ip counters")
+	}
+}
+
+func TestConsumerSkipUnnumbered(t *testing.T) {
+	// Not a real interface, so it has no addresses
+	unnumbered := &net.Interface{
+		Index: 9999,
+		Name:  "unnumbered0",
+		Flags: net.FlagUp | net.FlagMulticast,
+	}
+
+	if ok, err := hasIPv4Address(unnumbered); err != nil || ok {
+		t.Skipf("interface lookup doesn't report an address-less interface: %v %v", ok, err)
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12396}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{unnumbered}, func(ifi *net.Interface, _ net.Addr, payload []byte) {},
+		WithSkipUnnumbered())
+	if err != nil {
+		t.Fatalf("expected the interface to be skipped, got %v", err)
+	}
+	defer consumer.Close()
+
+	stats := consumer.InterfaceStats()
+	if len(stats) != 1 || stats[0].Joined || !errors.Is(stats[0].LastError, ErrNoIPv4Address) {
+		t.Fatalf("unexpected interface stats %+v", stats)
+	}
+}
+
+func TestInterfaceInfo(t *testing.T) {
+	infos, err := InterfaceInfo()
+	if err != nil {
+		t.Fatalf("failed to list interfaces: %v", err)
+	}
+
+	for _, info := range infos {
+		if info.Interface.Flags&net.FlagMulticast == 0 {
+			t.Fatalf("interface %s doesn't support multicast", info.Name)
+		}
+
+		if info.Name != info.Interface.Name || info.Index != info.Interface.Index {
+			t.Fatalf("inconsistent info for interface %s", info.Name)
+		}
+	}
+}
+
+func TestConsumerWorkerPoolSourceOrdering(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12397}
+
+	var (
+		mutex    sync.Mutex
+		received = make(map[string][]byte)
+	)
+
+	consumer, err := NewConsumer(addr, nil, func(ifi *net.Interface, src net.Addr, payload []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		received[src.String()] = append(received[src.String()], payload[0])
+	}, WithWorkerPool(4), WithSourceOrdering(), WithDrainOnClose())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+
+	for i := range 50 {
+		for port := 4000; port < 4008; port++ {
+			consumer.Inject(ifi, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: port}, []byte{byte(i)})
+		}
+	}
+
+	consumer.Close()
+
+	if len(received) != 8 {
+		t.Fatalf("expected packets from 8 sources, got %d", len(received))
+	}
+
+	for src, seqs := range received {
+		if len(seqs) != 50 {
+			t.Fatalf("expected 50 packets from %s, got %d", src, len(seqs))
+		}
+
+		for i, seq := range seqs {
+			if int(seq) != i {
+				t.Fatalf("packets from %s delivered out of order: %v", src, seqs)
+			}
+		}
+	}
+
+	if _, err := NewConsumer(addr, nil, nil, WithSourceOrdering()); err == nil {
+		t.Fatal("expected error for source ordering without a worker pool")
+	}
+
+	if _, err := NewConsumer(addr, nil, nil, WithWorkerPool(2), WithSerialDelivery()); err == nil {
+		t.Fatal("expected error combining a worker pool with serial delivery")
+	}
+}
+
+func TestConsumerRecvQueueBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("receive queue introspection is only supported on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12398}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	// Let the datagrams pile up in the kernel
+	consumer.SuspendDelivery()
+
+	sendLoopback(t, addr, []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+	time.Sleep(50 * time.Millisecond)
+
+	queued, err := consumer.RecvQueueBytes(loopback)
+	if err != nil {
+		t.Fatalf("failed to get receive queue: %v", err)
+	}
+
+	if queued == 0 {
+		t.Fatal("expected queued datagrams in the receive buffer")
+	}
+
+	consumer.ResumeDelivery()
+	time.Sleep(50 * time.Millisecond)
+
+	if queued, err = consumer.RecvQueueBytes(loopback); err != nil || queued != 0 {
+		t.Fatalf("expected an empty receive buffer, got %d %v", queued, err)
+	}
+
+	if _, err := consumer.RecvQueueBytes(&net.Interface{Index: 1000, Name: "other0"}); err == nil {
+		t.Fatal("expected error for an interface the consumer doesn't use")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12399}
+
+	packets, cancel, err := Subscribe(addr, []*net.Interface{loopback})
+	if err != nil {
+		t.Logf("failed to subscribe (may require privileges): %v", err)
+		return
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case pkt := <-packets:
+		if string(pkt.Payload) != "abc" || pkt.Interface.Index != 1 || !pkt.Dst.IP.Equal(addr.IP) {
+			t.Fatalf("unexpected packet %+v", pkt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	cancel()
+	cancel()
+
+	if _, ok := <-packets; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+}
+
+func TestConsumerSuspectedPruning(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12400}
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	is := consumer.ifaces[loopback.Index]
+
+	// Traffic returning long after the rejoin points at the sender
+	is.silenceRejoin.Store(time.Now().Add(-2 * pruningWindow).UnixNano())
+	consumer.Inject(loopback, src, []byte("abc"))
+
+	if consumer.InterfaceStats()[0].SuspectedPruning {
+		t.Fatal("unexpected pruning suspicion")
+	}
+
+	// Traffic returning right after the rejoin points at the switch
+	is.silenceRejoin.Store(time.Now().UnixNano())
+	consumer.Inject(loopback, src, []byte("abc"))
+
+	if !consumer.InterfaceStats()[0].SuspectedPruning {
+		t.Fatal("expected pruning to be suspected")
+	}
+}
+
+func TestConsumerEphemeralPort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fanout is only supported on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	var count atomic.Int32
+
+	// Several sockets must all end up on the same port
+	consumer, err := NewConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8)}, []*net.Interface{loopback},
+		func(ifi *net.Interface, _ net.Addr, payload []byte) {
+			count.Add(1)
+		}, WithFanout(2))
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	port, err := consumer.BoundPort(loopback)
+	if err != nil {
+		t.Fatalf("failed to get bound port: %v", err)
+	}
+
+	if port == 0 || consumer.Address().Port != port {
+		t.Fatalf("expected a chosen port reported by Address, got %d and %d", port, consumer.Address().Port)
+	}
+
+	for _, s := range consumer.ifaces[1].sockets {
+		if s.conn.LocalAddr().(*net.UDPAddr).Port != port {
+			t.Fatalf("socket bound to %s instead of port %d", s.conn.LocalAddr(), port)
+		}
+	}
+
+	sendLoopback(t, consumer.Address(), []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Fatalf("expected 1 packet, got %d", count.Load())
+	}
+}
+
+func TestConsumerEphemeralPortSerialDelivery(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	received := make(chan struct{}, 1)
+
+	// The worker runs while the port is picked, run with -race
+	consumer, err := NewConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8)}, []*net.Interface{loopback},
+		func(ifi *net.Interface, _ net.Addr, payload []byte) {
+			received <- struct{}{}
+		}, WithSerialDelivery())
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if consumer.Address().Port == 0 {
+		t.Fatal("expected the chosen port to be reported")
+	}
+
+	sendLoopback(t, consumer.Address(), []byte("abc"))
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("expected a packet on the chosen port")
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12401}
+
+	pc, err := NewPacketConn(addr, []*net.Interface{loopback})
+	if err != nil {
+		t.Logf("failed to create packet conn (may require privileges): %v", err)
+		return
+	}
+	defer pc.Close()
+
+	// Sent through the consumer's own socket and looped back to it
+	if _, err := pc.WriteTo([]byte("abcdef"), addr); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	if err := pc.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+
+	buf := make([]byte, 3)
+
+	n, src, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	if string(buf[:n]) != "abc" || src == nil {
+		t.Fatalf("unexpected read %q from %v", buf[:n], src)
+	}
+
+	_ = pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+
+	var netErr net.Error
+	if _, _, err := pc.ReadFrom(buf); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// Clearing the deadline wakes up a blocked read, which Close then ends
+	_ = pc.SetReadDeadline(time.Time{})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = pc.Close()
+	}()
+
+	if _, _, err := pc.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestPacketConnConsumerClosed(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12440}
+
+	pc, err := NewPacketConn(addr, []*net.Interface{loopback})
+	if err != nil {
+		t.Logf("failed to create packet conn (may require privileges): %v", err)
+		return
+	}
+	defer pc.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pc.Consumer().Close()
+	}()
+
+	read := make(chan error, 1)
+
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 16))
+		read <- err
+	}()
+
+	select {
+	case err := <-read:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the read to end when the consumer is closed")
+	}
+}
+func TestInterfacesByPattern(t *testing.T) {
+	ifis, err := InterfacesByPattern("l?")
+	if err != nil {
+		t.Fatalf("failed to resolve pattern: %v", err)
+	}
+
+	for _, ifi := range ifis {
+		if ifi.Name != "lo" {
+			t.Fatalf("unexpected interface %s", ifi.Name)
+		}
+	}
+
+	if _, err := InterfacesByPattern("eth["); err == nil {
+		t.Fatal("expected error for a malformed pattern")
+	}
+
+	consumer, err := NewConsumerByPattern(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12402}, "no-such-interface*",
+		func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if len(consumer.Interfaces()) != 0 {
+		t.Fatalf("expected no interfaces, got %d", len(consumer.Interfaces()))
+	}
+}
+
+func TestCallbackConcurrency(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12403}
+	cb := func(ifi *net.Interface, _ net.Addr, payload []byte) {}
+
+	for _, tc := range []struct {
+		cc      CallbackConcurrency
+		queues  int
+		workers int
+	}{
+		{PerInterface, 0, 0},
+		{Serial, 1, 0},
+		{WorkerPool(3), 1, 3},
+	} {
+		// The last option wins over the shorthand
+		consumer, err := NewConsumer(addr, nil, cb, WithSerialDelivery(), WithCallbackConcurrency(tc.cc))
+		if err != nil {
+			t.Fatalf("%s: failed to create consumer: %v", tc.cc, err)
+		}
+
+		if len(consumer.queues) != tc.queues || consumer.opts.workers != tc.workers {
+			t.Fatalf("%s: unexpected %d queues and %d workers", tc.cc, len(consumer.queues), consumer.opts.workers)
+		}
+
+		consumer.Close()
+	}
+
+	if _, err := NewConsumer(addr, nil, cb, WithCallbackConcurrency(WorkerPool(0))); err == nil {
+		t.Fatal("expected error for an empty worker pool")
+	}
+}
+
+func TestConsumerActiveReaders(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12404}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(ifi *net.Interface, _ net.Addr, payload []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (may require privileges): %v", err)
+		return
+	}
+
+	if n := consumer.ActiveReaders(); n != 1 {
+		t.Fatalf("expected 1 active reader, got %d", n)
+	}
+
+	consumer.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for consumer.ActiveReaders() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no active readers after Close, got %d", consumer.ActiveReaders())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	group := net.IPv4(224, 1, 1, 9)
+	d := NewDispatcher()
+
+	var got []*Packet
+
+	remove := d.Handle(&net.UDPAddr{IP: group, Port: 5000}, func(pkt *Packet) {
+		got = append(got, pkt)
+	})
+
+	buf := []byte("payload")
+
+	if !d.Dispatch(&Packet{Dst: &net.UDPAddr{IP: group, Port: 5000}, Payload: buf}) || len(got) != 1 {
+		t.Fatalf("expected the handler to match, got %d packets", len(got))
+	}
+
+	buf[0] = 'X'
+
+	if string(got[0].Payload) != "payload" {
+		t.Fatalf("expected the handler to get a copy of the payload, got %q", got[0].Payload)
+	}
+
+	if d.Dispatch(&Packet{Dst: &net.UDPAddr{IP: group, Port: 5001}}) {
+		t.Fatal("expected no match on another port")
+	}
+
+	if d.Dispatch(&Packet{Dst: &net.UDPAddr{IP: net.IPv4(224, 1, 1, 10), Port: 5000}}) {
+		t.Fatal("expected no match for another group")
+	}
+
+	if d.Dispatch(&Packet{}) {
+		t.Fatal("expected no match without a destination")
+	}
+
+	remove()
+	remove()
+
+	if n := d.Len(); n != 0 {
+		t.Fatalf("expected no destinations after removing the handler, got %d", n)
+	}
+
+	if d.Dispatch(&Packet{Dst: &net.UDPAddr{IP: group, Port: 5000}}) {
+		t.Fatal("expected no match after removing the handler")
+	}
+}
+
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	timers  []*fakeTimer
+}
+
+type fakeTicker struct {
+	c chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+func (t *fakeTicker) Stop()               {}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped atomic.Bool
+}
+
+func (t *fakeTimer) Stop() bool { return !t.stopped.Swap(true) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(time.Duration) Ticker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &fakeTicker{c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// advance moves the clock forward, fires due timers and ticks every ticker.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	tickers := slices.Clone(c.tickers)
+	timers := slices.Clone(c.timers)
+	c.mutex.Unlock()
+
+	for _, t := range timers {
+		if !now.Before(t.at) && !t.stopped.Swap(true) {
+			t.f()
+		}
+	}
+
+	for _, t := range tickers {
+		select {
+		case t.c <- now:
+		default:
+		}
+	}
+}
+
+func TestConsumerClock(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12405}
+	clock := newFakeClock()
+	received := make(chan struct{}, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		received <- struct{}{}
+	}, WithRejoinOnSilence(time.Minute), WithClock(clock))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	clock.advance(30 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+
+	if rejoins := consumer.Stats().Rejoins; rejoins != 0 {
+		t.Fatalf("expected no rejoin before the silence period, got %d", rejoins)
+	}
+
+	clock.advance(30 * time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for consumer.Stats().Rejoins == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a rejoin after the silence period")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("expected reception after rejoin")
+	}
+
+	if !consumer.InterfaceStats()[0].SuspectedPruning {
+		t.Fatal("expected pruning to be suspected for traffic resuming without time passing")
+	}
+
+	if err := WithClock(nil)(&consumerOptions{}); err == nil {
+		t.Fatal("expected an error for a nil clock")
+	}
+}
+
+func TestConsumerJoinedAddresses(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12406}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+
+	joined := consumer.JoinedAddresses()
+	if len(joined) != 1 || joined[0].String() != addr.String() {
+		t.Fatalf("expected [%s], got %v", addr, joined)
+	}
+
+	joined[0].Port++
+	if consumer.Address().Port != addr.Port {
+		t.Fatal("expected a copy of the joined address")
+	}
+
+	consumer.Close()
+
+	if joined := consumer.JoinedAddresses(); len(joined) != 0 {
+		t.Fatalf("expected no joined addresses after close, got %v", joined)
+	}
+}
+
+func TestPayloadArena(t *testing.T) {
+	a := &arena{size: 8, pool: newSlabPool(8)}
+
+	first, s := a.copy([]byte("abcd"))
+	second, s2 := a.copy([]byte("efgh"))
+
+	if string(first) != "abcd" || string(second) != "efgh" || s != s2 {
+		t.Fatalf("expected both payloads in one slab, got %q and %q", first, second)
+	}
+
+	if cap(first) != len(first) {
+		t.Fatal("expected the payload's capacity to end with it")
+	}
+
+	third, s3 := a.copy([]byte("ijkl"))
+	if string(third) != "ijkl" || s3 == s {
+		t.Fatal("expected a new slab once the first one is full")
+	}
+
+	if large, ls := a.copy([]byte("0123456789")); string(large) != "0123456789" || ls != nil {
+		t.Fatal("expected payloads larger than a slab to be allocated separately")
+	}
+
+	pkt := &Packet{Payload: first, slab: s}
+	pkt.Retain()
+	s2.release()
+
+	if pkt.slab != nil || string(pkt.Payload) != "abcd" {
+		t.Fatalf("expected a retained copy, got %q", pkt.Payload)
+	}
+
+	if s.refs.Load() != 0 || s.off != 0 {
+		t.Fatalf("expected the released slab to be reset, got %d refs at offset %d", s.refs.Load(), s.off)
+	}
+
+	if err := WithPayloadArena(100)(&consumerOptions{}); err == nil {
+		t.Fatal("expected an error for a slab smaller than a datagram")
+	}
+}
+
+func TestConsumerPayloadArena(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12407}
+
+	var (
+		mutex    sync.Mutex
+		retained []*Packet
+	)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithPacketCallback(func(pkt *Packet) {
+		pkt.Retain()
+
+		mutex.Lock()
+		retained = append(retained, pkt)
+		mutex.Unlock()
+	}), WithPayloadArena(maxMTU))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	for i := range 10 {
+		sendLoopback(t, addr, bytes.Repeat([]byte{byte(i)}, 500))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(retained) != 10 {
+		t.Fatalf("expected 10 packets, got %d", len(retained))
+	}
+
+	for i, pkt := range retained {
+		if !bytes.Equal(pkt.Payload, bytes.Repeat([]byte{byte(i)}, 500)) {
+			t.Fatalf("retained payload %d was overwritten", i)
+		}
+	}
+}
+
+func TestConsumerPayloadArenaQueueDrops(t *testing.T) {
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12438}
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{ifi}, nil, WithPacketCallback(func(*Packet) {
+		entered <- struct{}{}
+		<-release
+	}), WithSerialDelivery(), WithMaxQueuedBytes(1200), WithPayloadArena(maxMTU), WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	is := consumer.ifaces[ifi.Index]
+	a := &arena{size: maxMTU, pool: consumer.slabs}
+
+	// Blocks the worker, so the following packets stay queued
+	consumer.deliver(is, &Packet{Dst: addr, Payload: []byte("a")})
+	<-entered
+
+	payload, s := a.copy(make([]byte, 1201))
+	consumer.deliver(is, &Packet{Dst: addr, Payload: payload, slab: s})
+
+	if s.refs.Load() != 1 {
+		t.Fatalf("expected the packet dropped by the byte limit to release its slab, got %d refs", s.refs.Load())
+	}
+
+	for range queueSize {
+		consumer.deliver(is, &Packet{Dst: addr, Payload: []byte("a")})
+	}
+
+	consumer.Close()
+
+	// The queue is full, so the packet is dropped once the consumer closed
+	payload, s2 := a.copy([]byte("abcd"))
+	consumer.deliver(is, &Packet{Dst: addr, Payload: payload, slab: s2})
+
+	a.close()
+
+	if s.refs.Load() != 0 || s.off != 0 || s2 != s {
+		t.Fatalf("expected the slab to be ready for reuse, got %d refs at offset %d", s.refs.Load(), s.off)
+	}
+}
+func TestConsumerJoinAlreadyMember(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12408}
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	s := consumer.ifaces[loopback.Index].sockets[0]
+
+	if err := consumer.joinGroup(s.pc, loopback); err != nil {
+		t.Fatalf("expected joining again to succeed, got %v", err)
+	}
+
+	if !strings.Contains(logs.String(), "already joined") {
+		t.Fatalf("expected a debug log, got %q", logs.String())
+	}
+}
+
+func TestConsumerState(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12409}
+	errStale := errors.New("stream went stale")
+
+	var consumer *Consumer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		consumer.CloseWithError(errStale)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if state := consumer.State(); state != StateRunning || consumer.StopReason() != nil {
+		t.Fatalf("expected a running consumer, got %s with reason %v", state, consumer.StopReason())
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	deadline := time.Now().Add(time.Second)
+	for consumer.State() != StateClosed {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the callback to close the consumer")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	consumer.Close()
+
+	if err := consumer.StopReason(); !errors.Is(err, errStale) {
+		t.Fatalf("expected the callback's reason, got %v", err)
+	}
+
+	other, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	other.Close()
+
+	if err := other.StopReason(); !errors.Is(err, net.ErrClosed) || other.State().String() != "closed" {
+		t.Fatalf("expected net.ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestRedundancyFilter(t *testing.T) {
+	f := newRedundancyFilter(sequenceField{offset: 1, size: 1})
+
+	srcA := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 5000}
+	srcB := &net.UDPAddr{IP: net.IPv4(192, 168, 2, 1), Port: 5000}
+
+	accept := func(src net.Addr, seq byte) bool {
+		return f.accept(&Packet{Src: src, Payload: []byte{0xff, seq}})
+	}
+
+	if !accept(srcA, 1) || accept(srcB, 1) || !accept(srcB, 2) || accept(srcA, 2) {
+		t.Fatal("expected every sequence number to be accepted once across sources")
+	}
+
+	if !f.accept(&Packet{Src: srcA, Payload: []byte{0xff}}) || !f.accept(&Packet{Src: srcB, Payload: []byte{0xff}}) {
+		t.Fatal("expected packets without a sequence number to be accepted")
+	}
+
+	// Half the range of a single byte is remembered
+	for seq := 3; seq <= 128; seq++ {
+		accept(srcA, byte(seq))
+	}
+
+	if accept(srcB, 1) || !accept(srcA, 129) || !accept(srcB, 1) || accept(srcB, 3) {
+		t.Fatal("expected only the oldest sequence number to be forgotten")
+	}
+
+	if err := WithRedundancy(0, 3)(&consumerOptions{}); err == nil {
+		t.Fatal("expected an error for an invalid sequence number size")
+	}
+}
+
+func TestConsumerRedundancy(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12410}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		count.Add(1)
+	}, WithRedundancy(0, 2))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	for _, seq := range []byte{1, 1, 2, 1, 2, 3} {
+		sendLoopback(t, addr, []byte{0, seq, 'x'})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if n := count.Load(); n != 3 {
+		t.Fatalf("expected 3 packets, got %d", n)
+	}
+
+	if stats := consumer.Stats(); stats.Duplicates != 3 || stats.Packets != 3 {
+		t.Fatalf("expected 3 duplicates and 3 packets, got %d and %d", stats.Duplicates, stats.Packets)
+	}
+}
+
+func TestConsumerBPF(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12411}
+
+	// Accept only payloads starting with 'a', past the UDP header
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 8, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 'a', SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffffffff},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		t.Fatalf("failed to assemble filter: %v", err)
+	}
+
+	received := make(chan string, 4)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		received <- string(payload)
+	}, WithBPF(filter))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("bcd"))
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case payload := <-received:
+		if payload != "abc" {
+			t.Fatalf("expected only the matching payload, got %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the matching payload")
+	}
+
+	if _, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithBPF(filter), WithFanout(2)); err == nil {
+		t.Fatal("expected an error combining a BPF filter with fanout")
+	}
+}
+
+func TestConsumerReceiveBufferBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the doubled receive buffer size is specific to Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12412}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithReceiveBuffer(64*1024))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+
+	size, err := consumer.ReceiveBufferBytes(loopback)
+	if err != nil {
+		t.Fatalf("failed to get receive buffer size: %v", err)
+	}
+
+	if size != 2*64*1024 {
+		t.Fatalf("expected the kernel to double the requested size, got %d", size)
+	}
+
+	if err := consumer.SetReceiveBufferBytes(128 * 1024); err != nil {
+		t.Fatalf("failed to change receive buffer size: %v", err)
+	}
+
+	if size, err = consumer.ReceiveBufferBytes(loopback); err != nil || size != 2*128*1024 {
+		t.Fatalf("expected the changed size, got %d %v", size, err)
+	}
+
+	if err := consumer.Reset(); err != nil {
+		t.Fatalf("failed to reset consumer: %v", err)
+	}
+
+	if size, err = consumer.ReceiveBufferBytes(loopback); err != nil || size != 2*128*1024 {
+		t.Fatalf("expected the changed size to be kept for new sockets, got %d %v", size, err)
+	}
+
+	consumer.Close()
+
+	if _, err := consumer.ReceiveBufferBytes(loopback); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestConsumerLazyStart(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12413}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		count.Add(1)
+	}, WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create lazy consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if consumer.Active() || consumer.ActiveReaders() != 0 || len(consumer.JoinedAddresses()) != 0 {
+		t.Fatal("expected a lazy consumer to start inactive")
+	}
+
+	if err := consumer.Activate(); err != nil {
+		t.Logf("failed to activate consumer (expected on some systems): %v", err)
+		return
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if !consumer.Active() || count.Load() != 1 {
+		t.Fatalf("expected reception after activation, got %d packets", count.Load())
+	}
+
+	if err := consumer.Deactivate(); err != nil {
+		t.Fatalf("failed to deactivate consumer: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if consumer.Active() || count.Load() != 1 || consumer.ActiveReaders() != 0 {
+		t.Fatalf("expected no reception after deactivation, got %d packets", count.Load())
+	}
+
+	if err := consumer.Activate(); err != nil {
+		t.Fatalf("failed to activate consumer again: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 2 || consumer.Stats().Packets != 2 {
+		t.Fatalf("expected reception after reactivation, got %d packets", count.Load())
+	}
+
+	consumer.Close()
+
+	if err := consumer.Activate(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestListenerEpollMultiplexer(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	listener := NewListener([]*net.Interface{loopback}, WithEpollMultiplexer())
+	defer listener.Close()
+
+	if runtime.GOOS == "linux" && listener.mux == nil {
+		t.Fatal("expected a multiplexer on Linux")
+	}
+
+	addrA := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12414}
+	addrB := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 9), Port: 12414}
+
+	var countA, countB atomic.Int32
+
+	consumerA, err := listener.AddConsumer(addrA, func(*net.Interface, net.Addr, []byte) {
+		countA.Add(1)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+
+	consumerB, err := listener.AddConsumer(addrB, func(*net.Interface, net.Addr, []byte) {
+		countB.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	// Each socket read by the multiplexer counts as a reader
+	if consumerA.ActiveReaders() != 1 || consumerB.ActiveReaders() != 1 {
+		t.Fatalf("expected 1 reader per consumer, got %d and %d", consumerA.ActiveReaders(), consumerB.ActiveReaders())
+	}
+
+	sendLoopback(t, addrA, []byte("a1"))
+	sendLoopback(t, addrB, []byte("b1"), []byte("b2"))
+	time.Sleep(50 * time.Millisecond)
+
+	if countA.Load() != 1 || countB.Load() != 2 {
+		t.Fatalf("expected 1 and 2 packets, got %d and %d", countA.Load(), countB.Load())
+	}
+
+	consumerA.SuspendDelivery()
+
+	sendLoopback(t, addrA, []byte("a2"))
+	sendLoopback(t, addrB, []byte("b3"))
+	time.Sleep(50 * time.Millisecond)
+
+	if countA.Load() != 1 || countB.Load() != 3 {
+		t.Fatalf("expected only the other consumer to receive while suspended, got %d and %d", countA.Load(), countB.Load())
+	}
+
+	consumerA.ResumeDelivery()
+	time.Sleep(50 * time.Millisecond)
+
+	if countA.Load() != 2 {
+		t.Fatalf("expected the buffered packet after resuming, got %d", countA.Load())
+	}
+
+	consumerB.CloseAndRemove()
+
+	if runtime.GOOS == "linux" && consumerB.ActiveReaders() != 0 {
+		t.Fatalf("expected no readers after close, got %d", consumerB.ActiveReaders())
+	}
+
+	sendLoopback(t, addrA, []byte("a3"))
+	time.Sleep(50 * time.Millisecond)
+
+	if countA.Load() != 3 || countB.Load() != 3 {
+		t.Fatalf("expected reception to continue for the open consumer, got %d and %d", countA.Load(), countB.Load())
+	}
+}
+
+func TestConsumerStartTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding over a socket without SO_REUSEADDR only fails reliably on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	// A group no other test joins, so its membership shows this socket
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 99), Port: 12441}
+
+	joined := func() bool {
+		indexes, err := groupMemberships(addr.IP)
+		if err != nil {
+			t.Fatalf("failed to read memberships: %v", err)
+		}
+
+		return slices.Contains(indexes, loopback.Index)
+	}
+
+	// Holding the port stalls the setup in its bind retries
+	blocker, err := net.ListenUDP("udp4", &net.UDPAddr{Port: addr.Port})
+	if err != nil {
+		t.Fatalf("failed to hold port: %v", err)
+	}
+
+	goroutines := runtime.NumGoroutine()
+
+	_, err = NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithBindRetry(100, 10*time.Millisecond),
+		WithStartTimeout(50*time.Millisecond))
+	if !errors.Is(err, ErrStartTimeout) {
+		_ = blocker.Close()
+		t.Fatalf("expected ErrStartTimeout, got %v", err)
+	}
+
+	// The setup completes after the timeout and releases what it started
+	_ = blocker.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for joined() || runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the socket and goroutines to be released, got membership %t and %d of %d goroutines",
+				joined(), runtime.NumGoroutine(), goroutines)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenerEpollMultiplexerOnClose(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the multiplexer is only supported on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	listener := NewListener([]*net.Interface{loopback}, WithEpollMultiplexer())
+	defer listener.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12433}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	closed := make(chan struct{})
+
+	var once sync.Once
+
+	consumer, err := listener.AddConsumer(addr, func(*net.Interface, net.Addr, []byte) {
+		once.Do(func() {
+			close(entered)
+		})
+
+		<-release
+	}, WithOnClose(func(error) {
+		close(closed)
+	}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("expected the callback to be called")
+	}
+
+	consumer.Close()
+
+	// The multiplexer is still delivering, so the consumer hasn't stopped
+	select {
+	case <-closed:
+		t.Fatal("expected the close function to wait for the pending read")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the close function to be called")
+	}
+}
+
+func TestConsumerBatchCallback(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12415}
+	clock := newFakeClock()
+
+	var (
+		mutex   sync.Mutex
+		batches [][]string
+	)
+
+	cb := func(ifi *net.Interface, packets []Packet) {
+		batch := make([]string, 0, len(packets))
+		for _, pkt := range packets {
+			batch = append(batch, string(pkt.Payload))
+		}
+
+		mutex.Lock()
+		batches = append(batches, batch)
+		mutex.Unlock()
+	}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil,
+		WithBatchCallback(cb, 3, time.Second), WithClock(clock))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+	time.Sleep(50 * time.Millisecond)
+
+	clock.advance(time.Second)
+
+	sendLoopback(t, addr, []byte("e"))
+	time.Sleep(50 * time.Millisecond)
+
+	consumer.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if fmt.Sprint(batches) != "[[a b c] [d] [e]]" {
+		t.Fatalf("expected a full batch, one flushed by the timer and one on close, got %v", batches)
+	}
+
+	if err := WithBatchCallback(cb, 0, time.Second)(&consumerOptions{}); err == nil {
+		t.Fatal("expected an error for an empty batch")
+	}
+}
+
+func TestConsumerDstCheckUnsupported(t *testing.T) {
+	eth0 := &net.Interface{Index: 2, Name: "eth0", Flags: net.FlagUp | net.FlagMulticast}
+	errUnsupported := errors.New("protocol not available")
+
+	var logs syncBuffer
+
+	c := &Consumer{opts: defaultConsumerOptions()}
+	c.addr.Store(&net.UDPAddr{IP: net.IPv4(239, 1, 1, 1), Port: 5000})
+	c.opts.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	s := &socket{iface: &ifaceState{ifi: eth0}}
+
+	if err := c.dstCheckUnsupported(s, nil); err != nil || s.noDstCheck {
+		t.Fatalf("expected nothing to change without an error, got %v", err)
+	}
+
+	if err := c.dstCheckUnsupported(s, errUnsupported); err != nil || !s.noDstCheck {
+		t.Fatalf("expected a socket bound to the group to do without the check, got %v", err)
+	}
+
+	if !strings.Contains(logs.String(), "interface=eth0") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+
+	c.opts.bindAddress = net.IPv4zero
+	s = &socket{iface: &ifaceState{ifi: eth0}}
+
+	if err := c.dstCheckUnsupported(s, errUnsupported); !errors.Is(err, errUnsupported) || s.noDstCheck {
+		t.Fatalf("expected the error for a socket bound to another address, got %v", err)
+	}
+}
+
+func TestConsumerIgnoreMulticastFlag(t *testing.T) {
+	// Loopback supports multicast, but pretend it doesn't announce it
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12416}
+
+	skipping, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	if skipping.ActiveReaders() != 0 {
+		t.Fatal("expected an interface without the multicast flag to be skipped")
+	}
+
+	skipping.Close()
+
+	_, err = NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {}, WithRequireJoin())
+	if !errors.Is(err, ErrNoUsableInterfaces) {
+		t.Fatalf("expected ErrNoUsableInterfaces, got %v", err)
+	}
+
+	var count atomic.Int32
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		count.Add(1)
+	}, WithIgnoreMulticastFlag())
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Fatalf("expected reception despite the missing flag, got %d packets", count.Load())
+	}
+}
+
+func TestConsumerRequireJoin(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	// Skipped for lack of multicast support, so it is never joined
+	noMulticast := &net.Interface{Index: 1000, MTU: 1500, Name: "nomc0", Flags: net.FlagUp}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12448}
+	noop := func(*net.Interface, net.Addr, []byte) {}
+
+	_, err := NewConsumer(addr, []*net.Interface{noMulticast}, noop, WithRequireJoin())
+	if !errors.Is(err, ErrNoUsableInterfaces) {
+		t.Fatalf("expected ErrNoUsableInterfaces without a joined interface, got %v", err)
+	}
+
+	lazy, err := NewConsumer(addr, []*net.Interface{noMulticast}, noop, WithRequireJoin(), WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create lazy consumer: %v", err)
+	}
+	defer lazy.Close()
+
+	if err := lazy.Activate(); !errors.Is(err, ErrNoUsableInterfaces) {
+		t.Fatalf("expected Activate to fail with ErrNoUsableInterfaces, got %v", err)
+	}
+
+	// One joined interface is enough
+	consumer, err := NewConsumer(addr, []*net.Interface{noMulticast, loopback}, noop, WithRequireJoin())
+	if err != nil {
+		t.Fatalf("expected the consumer to start with one joined interface, got %v", err)
+	}
+	defer consumer.Close()
+
+	if !consumer.Active() {
+		t.Fatal("expected the consumer to be active")
+	}
+}
+
+func TestConsumerStatsLogInterval(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12417}
+	clock := newFakeClock()
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithStatsLogInterval(time.Minute), WithClock(clock), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"), []byte("def"))
+	time.Sleep(50 * time.Millisecond)
+
+	clock.advance(time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "multicast consumer stats") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stats to be logged")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if out := logs.String(); !strings.Contains(out, "group=224.1.1.8:12417") || !strings.Contains(out, "packets=2") {
+		t.Fatalf("expected the group's packet count, got %q", out)
+	}
+}
+
+func TestConsumerGoroutineLabels(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12444}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {}, WithWorkerPool(2))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if consumer.ActiveReaders() == 0 {
+		t.Skipf("consumer didn't start reading (expected on some systems): %v", consumer.InterfaceStats()[0].LastError)
+	}
+
+	// The goroutines label themselves once running
+	want := []string{
+		`"multicast_group":"224.1.1.8:12444"`,
+		`"multicast_interface":"lo"`,
+		`"multicast_worker":"0"`,
+		`"multicast_worker":"1"`,
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		var buf bytes.Buffer
+
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			t.Fatalf("failed to write goroutine profile: %v", err)
+		}
+
+		missing := slices.IndexFunc(want, func(label string) bool { return !strings.Contains(buf.String(), label) })
+		if missing < 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected label %s in the goroutine profile", want[missing])
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumerLockOSThread(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12446}
+
+	// The goroutine's stack trace tells whether it is locked to its thread
+	locked := func(opts ...ConsumerOption) bool {
+		result := make(chan bool, 1)
+
+		consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+			buf := make([]byte, 256)
+			n := runtime.Stack(buf, false)
+
+			select {
+			case result <- strings.Contains(string(buf[:n]), "locked to thread"):
+			default:
+			}
+		}, opts...)
+		if err != nil {
+			t.Fatalf("failed to create consumer: %v", err)
+		}
+		defer consumer.Close()
+
+		sendLoopback(t, addr, []byte("abc"))
+
+		select {
+		case l := <-result:
+			return l
+		case <-time.After(time.Second):
+			t.Skipf("no packet received (expected on some systems): %v", consumer.InterfaceStats()[0].LastError)
+			return false
+		}
+	}
+
+	if locked() {
+		t.Fatal("expected the read loop not to be locked to its thread by default")
+	}
+
+	if !locked(WithLockOSThread()) {
+		t.Fatal("expected the read loop to be locked to its thread with WithLockOSThread")
+	}
+}
+
+func TestConsumerCloseLeavesGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memberships are only listed on Linux")
+	}
+
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	// A group no other test joins, so its membership shows this socket
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 98), Port: 12447}
+
+	joined := func() bool {
+		indexes, err := groupMemberships(addr.IP)
+		if err != nil {
+			t.Fatalf("failed to read memberships: %v", err)
+		}
+
+		return slices.Contains(indexes, loopback.Index)
+	}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if !joined() {
+		t.Fatal("expected the group to be joined")
+	}
+
+	// A duplicate keeps the socket open past Close, so the membership is
+	// only gone if it was left explicitly
+	f, err := consumer.ifaces[loopback.Index].sockets[0].conn.File()
+	if err != nil {
+		t.Fatalf("failed to duplicate socket: %v", err)
+	}
+	defer f.Close()
+
+	consumer.Close()
+
+	if joined() {
+		t.Fatal("expected the group to be left before the socket is closed")
+	}
+}
+
+func TestScheduledConsumer(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12418}
+	clock := newFakeClock()
+	start := clock.Now().Add(time.Hour)
+
+	var count atomic.Int32
+
+	consumer, err := NewScheduledConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {
+		count.Add(1)
+	}, start, start.Add(time.Hour), WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create scheduled consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if consumer.Active() {
+		t.Fatal("expected the consumer to be inactive before the start")
+	}
+
+	clock.advance(time.Hour)
+
+	if !consumer.Active() {
+		t.Logf("consumer didn't join at the start (expected on some systems): %v", consumer.InterfaceStats()[0].LastError)
+		return
+	}
+
+	sendLoopback(t, addr, []byte("abc"))
+	time.Sleep(50 * time.Millisecond)
+
+	if count.Load() != 1 {
+		t.Fatalf("expected reception during the schedule, got %d packets", count.Load())
+	}
+
+	clock.advance(time.Hour)
+
+	if state := consumer.State(); state != StateClosed {
+		t.Fatalf("expected the consumer to be closed at the end, got %s", state)
+	}
+
+	if _, err := NewScheduledConsumer(addr, []*net.Interface{loopback}, nil, start, start, WithClock(clock)); err == nil {
+		t.Fatal("expected an error for an empty schedule")
+	}
+
+	if _, err := NewScheduledConsumer(addr, []*net.Interface{loopback}, nil, start, start.Add(time.Hour), WithClock(clock)); err == nil {
+		t.Fatal("expected an error for a schedule that already ended")
+	}
+}
+
+func TestScheduledConsumerClosedEarly(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12442}
+	clock := newFakeClock()
+	start := clock.Now().Add(time.Hour)
+
+	consumer, err := NewScheduledConsumer(addr, []*net.Interface{loopback}, nil, start, start.Add(time.Hour), WithClock(clock))
+	if err != nil {
+		t.Fatalf("failed to create scheduled consumer: %v", err)
+	}
+
+	consumer.Close()
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	if len(clock.timers) != 2 {
+		t.Fatalf("expected a start and an end timer, got %d", len(clock.timers))
+	}
+
+	for _, timer := range clock.timers {
+		if !timer.stopped.Load() {
+			t.Fatal("expected the schedule's timers to be stopped on close")
+		}
+	}
+}
+
+func TestConsumerPacketInfo(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12419}
+	received := make(chan *Packet, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithPacketCallback(func(pkt *Packet) {
+		received <- pkt
+	}), WithPacketInfo(), WithTrustSocketFiltering())
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case pkt := <-received:
+		if pkt.IfIndex != loopback.Index || !pkt.Dst.IP.Equal(addr.IP) {
+			t.Fatalf("expected destination %s on interface %d, got %s on %d", addr.IP, loopback.Index, pkt.Dst.IP, pkt.IfIndex)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a packet")
+	}
+}
+
+func TestConsumerControlMessage(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12420}
+	received := make(chan *Packet, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithPacketCallback(func(pkt *Packet) {
+		received <- pkt
+	}), WithControlMessage())
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, []byte("abc"))
+
+	select {
+	case pkt := <-received:
+		cm := pkt.ControlMessage
+		if cm == nil {
+			t.Fatal("expected the control message")
+		}
+
+		if !cm.Dst.Equal(addr.IP) || cm.IfIndex != loopback.Index || cm.TTL == 0 {
+			t.Fatalf("expected destination, interface and TTL, got %s", cm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a packet")
+	}
+}
+
+func TestConsumerName(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12421}
+	clock := newFakeClock()
+
+	var logs syncBuffer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithName("primary-audio"), WithStatsLogInterval(time.Minute), WithClock(clock),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if name := consumer.Name(); name != "primary-audio" {
+		t.Fatalf("expected the name, got %q", name)
+	}
+
+	if s := consumer.String(); s != "primary-audio (224.1.1.8:12421)" {
+		t.Fatalf("expected the name and group, got %q", s)
+	}
+
+	if stats := consumer.InterfaceStats(); len(stats) != 1 || stats[0].Consumer != "primary-audio" {
+		t.Fatalf("expected the name in the interface stats, got %+v", stats)
+	}
+
+	// The stats logger may not have started its ticker yet
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "consumer=primary-audio") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the name in the logs, got %q", logs.String())
+		}
+
+		clock.advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumerExpectedSourcePort(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	if _, err := NewConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8)}, nil, nil, WithExpectedSourcePort(0)); err == nil {
+		t.Fatal("expected an invalid source port to be rejected")
+	}
+
+	sender := newLoopbackSender(t)
+	defer sender.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12422}
+	received := make(chan string, 2)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		received <- string(payload)
+	}, WithExpectedSourcePort(sender.LocalAddr().(*net.UDPAddr).Port))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	// Sent from another port
+	sendLoopback(t, addr, []byte("spoofed"))
+
+	if _, err := sender.WriteTo([]byte("expected"), nil, addr); err != nil {
+		t.Fatalf("failed to send packet: %v", err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload != "expected" {
+			t.Fatalf("expected the packet from the expected port, got %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a packet")
+	}
+
+	if stats := consumer.Stats(); stats.SourcePortMismatches != 1 || stats.Packets != 1 {
+		t.Fatalf("expected 1 packet and 1 source port mismatch, got %+v", stats)
+	}
+}
+
+func TestConsumerExpectedSourcePortInject(t *testing.T) {
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12437}
+
+	var got []string
+
+	// Lazily started, so that injected packets are counted on ifi
+	consumer, err := NewConsumer(addr, []*net.Interface{ifi}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		got = append(got, string(payload))
+	}, WithExpectedSourcePort(4000), WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	consumer.Inject(ifi, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4001}, []byte("spoofed"))
+	consumer.Inject(ifi, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}, []byte("expected"))
+
+	if len(got) != 1 || got[0] != "expected" {
+		t.Fatalf("expected only the packet from the expected port, got %q", got)
+	}
+
+	if stats := consumer.Stats(); stats.SourcePortMismatches != 1 || stats.Packets != 1 {
+		t.Fatalf("expected 1 packet and 1 source port mismatch, got %+v", stats)
+	}
+}
+func TestConsumerAdaptiveReadBuffer(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   maxMTU,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	if _, err := NewConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8)}, nil, nil, WithAdaptiveReadBuffer(maxMTU-1)); err == nil {
+		t.Fatal("expected a limit below the MTU to be rejected")
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12423}
+	sizes := make(chan int, 3)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		sizes <- len(payload)
+	}, WithAdaptiveReadBuffer(4000))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, make([]byte, 2000), make([]byte, 2000), make([]byte, 5000))
+
+	// The first datagram is truncated, the second fits the grown buffer
+	for i, want := range []int{maxMTU, 2000} {
+		select {
+		case size := <-sizes:
+			if size != want {
+				t.Fatalf("expected payload %d to have %d bytes, got %d", i, want, size)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for packet")
+		}
+	}
+
+	select {
+	case size := <-sizes:
+		if size != 2000 && size != 4000 {
+			t.Fatalf("expected the oversized payload to be truncated, got %d bytes", size)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	if size := consumer.readBuffer.Load(); size != 4000 {
+		t.Fatalf("expected the buffer to grow to the limit, got %d", size)
+	}
+}
+
+func TestConsumerOnClose(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12424}
+	reasons := make(chan error, 2)
+
+	var consumer *Consumer
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {},
+		WithOnClose(func(reason error) {
+			if n := consumer.ActiveReaders(); n != 0 {
+				t.Errorf("expected no active readers, got %d", n)
+			}
+
+			reasons <- reason
+		}))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+
+	errFatal := errors.New("fatal")
+
+	consumer.CloseWithError(errFatal)
+	consumer.Close()
+
+	select {
+	case reason := <-reasons:
+		if !errors.Is(reason, errFatal) {
+			t.Fatalf("expected the stop reason, got %v", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the close hook to be called")
+	}
+
+	select {
+	case reason := <-reasons:
+		t.Fatalf("expected the close hook to be called once, got %v", reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConsumerSourceFilter(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12425}
+	received := make(chan string, 4)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		received <- string(payload)
+	})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	if err := consumer.SetSourceFilter(SourceFilterInclude, nil); err == nil {
+		t.Fatal("expected an include filter without sources to be rejected")
+	}
+
+	if err := consumer.SetSourceFilter(SourceFilterAny, []net.IP{net.IPv4(127, 0, 0, 1)}); err == nil {
+		t.Fatal("expected an any-source filter with sources to be rejected")
+	}
+
+	expect := func(payload string) {
+		t.Helper()
+
+		select {
+		case got := <-received:
+			if got != payload {
+				t.Fatalf("expected %q, got %q", payload, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q", payload)
+		}
+	}
+
+	if err := consumer.SetSourceFilter(SourceFilterExclude, []net.IP{net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Skipf("source filters not supported: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("excluded"))
+
+	if err := consumer.SetSourceFilter(SourceFilterAny, nil); err != nil {
+		t.Fatalf("failed to switch back to any source: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("any"))
+	expect("any")
+
+	if err := consumer.SetSourceFilter(SourceFilterInclude, []net.IP{net.IPv4(127, 0, 0, 1)}); err != nil {
+		t.Fatalf("failed to switch to include: %v", err)
+	}
+
+	sendLoopback(t, addr, []byte("included"))
+	expect("included")
+
+	consumer.Close()
+
+	if err := consumer.SetSourceFilter(SourceFilterAny, nil); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestConsumerSourceFilterRollback(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12434}
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, func(*net.Interface, net.Addr, []byte) {})
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sources := func(prefix byte, n int) []net.IP {
+		ips := []net.IP{net.IPv4(127, 0, 0, 1)}
+		for i := range n {
+			ips = append(ips, net.IPv4(198, 51, prefix, byte(i+1)))
+		}
+
+		return ips
+	}
+
+	if err := consumer.SetSourceFilter(SourceFilterExclude, sources(0, 0)); err != nil {
+		t.Skipf("source filters not supported: %v", err)
+	}
+
+	// More sources than the kernel allows per socket, by default 10
+	if err := consumer.SetSourceFilter(SourceFilterExclude, sources(1, 64)); err == nil {
+		t.Skip("the kernel accepted 65 sources")
+	}
+
+	if filter := consumer.loadSourceFilter(); len(filter.sources) != 1 {
+		t.Fatalf("expected the old filter to be kept, got %d sources", len(filter.sources))
+	}
+
+	// Only fits if the sources added before the failure were removed again
+	if err := consumer.SetSourceFilter(SourceFilterExclude, sources(2, 9)); err != nil {
+		t.Fatalf("expected the failed change to be rolled back: %v", err)
+	}
+}
+
+func TestAdaptPayloadCallback(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12426}
+
+	var got []string
+
+	consumer, err := NewConsumer(addr, nil, AdaptPayloadCallback(func(ifi *net.Interface, payload []byte) {
+		got = append(got, fmt.Sprintf("%d:%s", ifi.Index, payload))
+	}))
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	consumer.Inject(&net.Interface{Index: 3}, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}, []byte("abc"))
+
+	if len(got) != 1 || got[0] != "3:abc" {
+		t.Fatalf("unexpected payloads %q", got)
+	}
+}
+
+func TestConsumerMaxCaptureBytes(t *testing.T) {
+	loopback := &net.Interface{
+		Index: 1,
+		MTU:   65536,
+		Name:  "lo",
+		Flags: net.FlagUp | net.FlagLoopback | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12427}
+	received := make(chan *Packet, 1)
+
+	consumer, err := NewConsumer(addr, []*net.Interface{loopback}, nil, WithPacketCallback(func(pkt *Packet) {
+		received <- pkt
+	}), WithMaxCaptureBytes(10))
+	if err != nil {
+		t.Logf("failed to create consumer (expected on some systems): %v", err)
+		return
+	}
+	defer consumer.Close()
+
+	sendLoopback(t, addr, bytes.Repeat([]byte{'x'}, 100))
+
+	select {
+	case pkt := <-received:
+		if len(pkt.Payload) != 10 || pkt.Length != 100 || pkt.Truncated {
+			t.Fatalf("expected 10 of 100 bytes without truncation, got %d of %d, truncated %t",
+				len(pkt.Payload), pkt.Length, pkt.Truncated)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a packet")
+	}
+
+	if stats := consumer.Stats(); stats.Bytes != 100 || stats.Truncated != 0 {
+		t.Fatalf("expected the full size counted without truncation, got %+v", stats)
+	}
+}
+
+func TestConsumerJoinRetry(t *testing.T) {
+	// An interface that doesn't exist fails to join like one that isn't up
+	missing := &net.Interface{
+		Index: 99999,
+		MTU:   1500,
+		Name:  "missing0",
+		Flags: net.FlagUp | net.FlagMulticast,
+	}
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12428}
+
+	if _, err := NewConsumer(addr, []*net.Interface{missing}, func(*net.Interface, net.Addr, []byte) {}); !errors.Is(err, ErrNetworkUnavailable) {
+		t.Skipf("join on a missing interface didn't report the network unavailable: %v", err)
+	}
+
+	clock := newFakeClock()
+
+	consumer, err := NewConsumer(addr, []*net.Interface{missing}, func(*net.Interface, net.Addr, []byte) {},
+		WithJoinRetry(time.Second), WithClock(clock))
+	if err != nil {
+		t.Fatalf("expected the join to be deferred, got %v", err)
+	}
+	defer consumer.Close()
+
+	stats := consumer.InterfaceStats()
+	if len(stats) != 1 || stats[0].Joined || !errors.Is(stats[0].LastError, ErrNetworkUnavailable) {
+		t.Fatalf("expected the deferred join to be reported, got %+v", stats)
+	}
+
+	// Still unavailable on retry
+	consumer.retryDeferredJoins()
+
+	if !consumer.ifaces[missing.Index].deferred.Load() {
+		t.Fatal("expected the join to stay deferred")
+	}
+}
+
+func TestListenerSharedSocketsJoinRetry(t *testing.T) {
+	missing := &net.Interface{
+		Index: 99999,
+		MTU:   1500,
+		Name:  "missing0",
+		Flags: net.FlagUp | net.FlagMulticast,
+	}
+
+	listener := NewListener([]*net.Interface{missing}, WithSharedSockets())
+	defer listener.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12436}
+
+	// Opening the shared socket fails like the join of an interface that
+	// isn't up
+	_, err := listener.AddConsumer(addr, func(*net.Interface, net.Addr, []byte) {})
+	if !errors.Is(err, ErrNetworkUnavailable) {
+		t.Skipf("shared socket on a missing interface didn't report the network unavailable: %v", err)
+	}
+
+	consumer, err := listener.AddConsumer(addr, func(*net.Interface, net.Addr, []byte) {},
+		WithJoinRetry(time.Second), WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatalf("expected the join to be deferred, got %v", err)
+	}
+
+	if !consumer.ifaces[missing.Index].deferred.Load() {
+		t.Fatal("expected the join to be deferred")
+	}
+}
+
+func TestConsumerValidator(t *testing.T) {
+	ifi := &net.Interface{Index: 1, Name: "lo"}
+	addr := &net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: 12429}
+
+	var got []string
+
+	// Lazily started, so that injected packets are counted on ifi
+	consumer, err := NewConsumer(addr, []*net.Interface{ifi}, func(_ *net.Interface, _ net.Addr, payload []byte) {
+		got = append(got, string(payload))
+	}, WithValidator(func(payload []byte) bool {
+		return len(payload) > 0 && int(payload[0]) == len(payload)-1
+	}), WithLazyStart())
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+
+	consumer.Inject(ifi, src, []byte("\x03abc"))
+	consumer.Inject(ifi, src, []byte("\x05abc"))
+	consumer.Inject(ifi, src, nil)
+
+	if len(got) != 1 || got[0] != "\x03abc" {
+		t.Fatalf("expected only the valid payload, got %q", got)
+	}
+
+	if stats := consumer.Stats(); stats.Invalid != 2 || stats.Packets != 1 {
+		t.Fatalf("expected 2 invalid and 1 valid packet, got %d and %d", stats.Invalid, stats.Packets)
+	}
+}
+
+func TestListenerForEachConsumer(t *testing.T) {
+	listener := NewListener(nil)
+	defer listener.Close()
+
+	for _, port := range []int{12430, 12431} {
+		if _, err := listener.AddConsumer(&net.UDPAddr{IP: net.IPv4(224, 1, 1, 8), Port: port}, func(*net.Interface, net.Addr, []byte) {}); err != nil {
+			t.Fatalf("failed to add consumer: %v", err)
+		}
+	}
+
+	var visited []int
+
+	// Removing consumers while iterating doesn't affect the iteration
+	listener.ForEachConsumer(func(consumer *Consumer) {
+		visited = append(visited, consumer.Address().Port)
+		listener.RemoveConsumer(consumer)
+	})
+
+	if len(visited) != 2 || visited[0] != 12430 || visited[1] != 12431 {
+		t.Fatalf("expected both consumers to be visited, got %v", visited)
+	}
+
+	if n := len(listener.Consumers()); n != 0 {
+		t.Fatalf("expected no consumers after removing them, got %d", n)
+	}
+}
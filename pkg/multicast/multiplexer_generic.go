@@ -0,0 +1,25 @@
+//go:build !linux
+
+package multicast
+
+import (
+	"errors"
+)
+
+// multiplexer is only implemented on Linux, elsewhere every socket keeps its
+// own read loop.
+type multiplexer struct{}
+
+func newMultiplexer() (*multiplexer, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (m *multiplexer) add(_ *Consumer, _ *socket) error {
+	return errors.ErrUnsupported
+}
+
+func (m *multiplexer) remove(_ *socket) {}
+
+func (m *multiplexer) unpark(_ *Consumer) {}
+
+func (m *multiplexer) close() {}
@@ -0,0 +1,338 @@
+//go:build linux
+
+package multicast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// muxBatch is the number of datagrams read from a socket per wakeup before
+// the other ready sockets get their turn.
+const muxBatch = 64
+
+// multiplexer reads the sockets of many consumers from a single goroutine
+// waiting on an epoll instance, instead of a read loop per socket. Sockets
+// are registered one-shot and rearmed after every batch, so a consumer
+// whose delivery is suspended is simply not rearmed until it resumes.
+type multiplexer struct {
+	epfd   int
+	wakefd int
+
+	mutex   sync.Mutex
+	entries map[int32]*muxEntry
+	ids     map[*socket]int32
+	nextID  int32
+	closed  bool
+}
+
+type muxEntry struct {
+	consumer *Consumer
+	socket   *socket
+	id       int32
+
+	// Set while the socket isn't rearmed because delivery is suspended
+	parked bool
+
+	// Set while the loop reads the socket, and once the socket was removed
+	// meanwhile, for the loop to release the consumer's reader afterwards
+	reading bool
+	removed bool
+}
+
+// done releases the consumer's reader held by e's socket.
+func (e *muxEntry) done() {
+	e.consumer.activeReaders.Add(-1)
+	e.consumer.readers.Done()
+}
+
+// The epoll data of the eventfd that stops the loop
+const muxWakeID = 0
+
+func newMultiplexer() (*multiplexer, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+
+	wakefd, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: muxWakeID}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakefd, &ev); err != nil {
+		_ = unix.Close(wakefd)
+		_ = unix.Close(epfd)
+
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+
+	m := &multiplexer{
+		epfd:    epfd,
+		wakefd:  wakefd,
+		entries: make(map[int32]*muxEntry),
+		ids:     make(map[*socket]int32),
+		nextID:  muxWakeID + 1,
+	}
+
+	go m.loop()
+
+	return m, nil
+}
+
+// add starts reading s for c. Like a read loop, the socket counts as one of
+// the consumer's readers until it is removed and no read of it is pending.
+func (m *multiplexer) add(c *Consumer, s *socket) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e := &muxEntry{consumer: c, socket: s, id: m.nextID}
+	m.nextID++
+
+	if err := m.ctl(e, unix.EPOLL_CTL_ADD); err != nil {
+		return err
+	}
+
+	m.entries[e.id] = e
+	m.ids[s] = e.id
+
+	c.readers.Add(1)
+	c.activeReaders.Add(1)
+
+	return nil
+}
+
+// remove stops reading s. It must be called before s is closed.
+func (m *multiplexer) remove(s *socket) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	id, ok := m.ids[s]
+	if !ok {
+		return
+	}
+
+	e := m.entries[id]
+
+	delete(m.ids, s)
+	delete(m.entries, id)
+
+	if e.reading {
+		e.removed = true
+	} else {
+		e.done()
+	}
+
+	if m.closed {
+		return
+	}
+
+	_ = s.raw.Control(func(fd uintptr) {
+		_ = unix.EpollCtl(m.epfd, unix.EPOLL_CTL_DEL, int(fd), nil)
+	})
+}
+
+// unpark rearms the sockets of c that were left unarmed while its delivery
+// was suspended.
+func (m *multiplexer) unpark(c *Consumer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, e := range m.entries {
+		if e.consumer == c && e.parked {
+			e.parked = false
+			_ = m.ctl(e, unix.EPOLL_CTL_MOD)
+		}
+	}
+}
+
+// ctl registers or rearms e's socket for a single notification. The
+// socket's descriptor is only used while it is held open, so that a closed
+// and reused descriptor is never touched, and likewise the epoll instance
+// only until the multiplexer is closed. The mutex must be held.
+func (m *multiplexer) ctl(e *muxEntry, op int) error {
+	if m.closed {
+		return net.ErrClosed
+	}
+
+	var cerr error
+
+	err := e.socket.raw.Control(func(fd uintptr) {
+		ev := unix.EpollEvent{Events: unix.EPOLLIN | unix.EPOLLONESHOT, Fd: e.id}
+		cerr = unix.EpollCtl(m.epfd, op, int(fd), &ev)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cerr != nil {
+		return os.NewSyscallError("epoll_ctl", cerr)
+	}
+
+	return nil
+}
+
+// close stops the loop. The sockets stay open, they belong to the consumers.
+func (m *multiplexer) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+
+	var one [8]byte
+	binary.NativeEndian.PutUint64(one[:], 1)
+	_, _ = unix.Write(m.wakefd, one[:])
+}
+
+func (m *multiplexer) loop() {
+	events := make([]unix.EpollEvent, 128)
+	buf := make([]byte, maxMTU)
+	oob := make([]byte, oobSize)
+
+	for {
+		n, err := unix.EpollWait(m.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+
+			m.fail(os.NewSyscallError("epoll_wait", err))
+
+			return
+		}
+
+		for _, ev := range events[:n] {
+			if ev.Fd == muxWakeID {
+				_ = unix.Close(m.wakefd)
+				_ = unix.Close(m.epfd)
+
+				return
+			}
+
+			m.mutex.Lock()
+			e := m.entries[ev.Fd]
+			if e != nil {
+				e.reading = true
+			}
+			m.mutex.Unlock()
+
+			if e == nil {
+				continue
+			}
+
+			if size := int(e.consumer.readBuffer.Load()); size > len(buf) {
+				buf = make([]byte, size)
+			}
+
+			rearm := m.read(e, buf, oob)
+
+			m.mutex.Lock()
+			e.reading = false
+
+			if e.removed {
+				e.done()
+			} else if rearm {
+				_ = m.ctl(e, unix.EPOLL_CTL_MOD)
+			}
+			m.mutex.Unlock()
+		}
+	}
+}
+
+// fail stops the multiplexer after the epoll instance failed, and closes
+// the consumers whose sockets it read with err, as they won't receive
+// anything anymore.
+func (m *multiplexer) fail(err error) {
+	m.mutex.Lock()
+
+	m.closed = true
+
+	var consumers []*Consumer
+
+	for _, e := range m.entries {
+		if !slices.Contains(consumers, e.consumer) {
+			consumers = append(consumers, e.consumer)
+		}
+	}
+
+	_ = unix.Close(m.wakefd)
+	_ = unix.Close(m.epfd)
+
+	m.mutex.Unlock()
+
+	for _, c := range consumers {
+		c.CloseWithError(fmt.Errorf("multiplexer failed: %w", err))
+	}
+}
+
+// read delivers up to a batch of datagrams waiting on e's socket, and
+// reports whether the socket has to be rearmed, which it doesn't once the
+// consumer is closed or its delivery suspended.
+func (m *multiplexer) read(e *muxEntry, buf, oob []byte) bool {
+	c, s := e.consumer, e.socket
+
+	for range muxBatch {
+		if m.park(e) {
+			return false
+		}
+
+		n, oobn, flags, src, err := s.tryReadMsg(buf, oob)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) {
+				break
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return false
+			}
+
+			s.iface.setError(err)
+
+			break
+		}
+
+		if pkt := c.receive(s, buf, oob[:oobn], n, flags, src, nil); pkt != nil {
+			c.deliver(s.iface, pkt)
+		}
+	}
+
+	return true
+}
+
+// park reports whether reading e's socket has to stop because the consumer
+// is closed or its delivery suspended, and in the latter case leaves the
+// socket for unpark to rearm.
+func (m *multiplexer) park(e *muxEntry) bool {
+	c := e.consumer
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return true
+	}
+
+	if c.resume != nil {
+		m.mutex.Lock()
+		e.parked = true
+		m.mutex.Unlock()
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,825 @@
+package multicast
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/ipv4"
+)
+
+type ConsumerOption func(*consumerOptions) error
+
+type consumerOptions struct {
+	fanout       int
+	bindDevice   string
+	startTimeout time.Duration
+	groupCb      ConsumerGroupPacketCallback
+	packetCb     PacketCallback
+
+	bindRetries      int
+	bindRetryBackoff time.Duration
+
+	serial bool
+
+	trustSocketFiltering bool
+
+	bindAddress net.IP
+
+	tap *tap
+
+	logger        *slog.Logger
+	rejoinSilence time.Duration
+
+	ecn bool
+
+	maxQueuedBytes int
+
+	pool *socketPool
+
+	drainOnClose bool
+
+	multicastAll bool
+
+	receiveBuffer int
+
+	callbackDeadline time.Duration
+
+	timestamping bool
+
+	sequence *sequenceTracker
+
+	lockOSThread bool
+
+	verifyJoin bool
+
+	subnetCheck bool
+
+	skipUnnumbered bool
+
+	workers        int
+	sourceOrdering bool
+
+	clock Clock
+
+	arenaSize int
+
+	redundancy *redundancyFilter
+
+	filter []bpf.RawInstruction
+
+	lazy bool
+
+	mux *multiplexer
+
+	batch *batcher
+
+	ignoreMulticastFlag bool
+
+	statsLogInterval time.Duration
+
+	packetInfo bool
+
+	requireJoin bool
+
+	controlMessage bool
+
+	name string
+
+	expectedSourcePort int
+
+	readBufferLimit int
+
+	onClose func(reason error)
+
+	maxCaptureBytes int
+
+	joinRetryInterval time.Duration
+
+	validator func(payload []byte) bool
+}
+
+func defaultConsumerOptions() consumerOptions {
+	return consumerOptions{
+		fanout: 1,
+		logger: slog.New(slog.DiscardHandler),
+		clock:  realClock{},
+	}
+}
+
+// WithFanout opens n sockets per interface and lets the kernel split the
+// received datagrams between them, each served by its own read loop.
+// The kernel hands every socket its own copy of each datagram, and a filter
+// on each socket drops those whose flow hash does not map to it, so every
+// datagram is delivered to exactly one socket. The hash covers the sender
+// and destination, hence all datagrams of one flow, such as a single
+// high-rate sender, end up on the same socket and are not split. Traffic
+// without a hash from the NIC all lands on the first socket. Only supported
+// on Linux.
+func WithFanout(n int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if n < 1 {
+			return fmt.Errorf("invalid fanout %d: must be at least 1", n)
+		}
+
+		o.fanout = n
+
+		return nil
+	}
+}
+
+// WithBindDevice binds the sockets to the named device (SO_BINDTODEVICE)
+// instead of the interface the group is joined on. This is needed to receive
+// multicast inside a VRF: pass the VRF master device here while the consumer's
+// interfaces, which must be enslaved to that VRF, are used for the group
+// membership. Only supported on Linux.
+func WithBindDevice(name string) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.bindDevice = name
+
+		return nil
+	}
+}
+
+// WithStartTimeout bounds the total time NewConsumer spends opening sockets
+// and joining the group on all interfaces. When it is exceeded, NewConsumer
+// returns an error wrapping ErrStartTimeout, and any sockets opened by the
+// still running setup are closed once it returns.
+func WithStartTimeout(d time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.startTimeout = d
+
+		return nil
+	}
+}
+
+// WithGroupCallback delivers packets to cb instead of the consumer's regular
+// callback, additionally passing the group address each packet was sent to.
+func WithGroupCallback(cb ConsumerGroupPacketCallback) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.groupCb = cb
+
+		return nil
+	}
+}
+
+// WithBindRetry retries binding a socket up to retries more times when the
+// address is still in use, for example right after a restart. The wait
+// between attempts starts at backoff and doubles after every attempt. When
+// all attempts fail, the error wraps ErrBindRetriesExhausted.
+func WithBindRetry(retries int, backoff time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if retries < 0 {
+			return fmt.Errorf("invalid bind retries %d: must not be negative", retries)
+		}
+
+		o.bindRetries = retries
+		o.bindRetryBackoff = backoff
+
+		return nil
+	}
+}
+
+// WithSerialDelivery funnels the packets of all interfaces through a single
+// goroutine, so the callback is never invoked concurrently. Packets are
+// queued between the read loops and that goroutine, and the read loops stop
+// reading while the queue is full.
+func WithSerialDelivery() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.serial = true
+
+		return nil
+	}
+}
+
+// WithTrustSocketFiltering skips the per-packet check of the destination
+// address and doesn't request it from the kernel at all. Each socket is bound
+// to the group address, so the kernel already only hands it datagrams sent to
+// that group.
+func WithTrustSocketFiltering() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.trustSocketFiltering = true
+
+		return nil
+	}
+}
+
+// WithBindAddress binds the sockets to ip instead of the group address, while
+// the group is still joined as usual. The kernel only hands a socket the
+// multicast datagrams whose destination matches its bound address, so ip must
+// be either the unspecified address (0.0.0.0) or a multicast address. A
+// local unicast address is rejected, as a socket bound to it would never
+// receive the group's datagrams; use WithBindDevice to tie the sockets to
+// the interface owning that address instead. When bound to 0.0.0.0, the
+// socket also sees unicast traffic and other groups on the same port, which
+// are filtered out by checking each packet's destination, so this option
+// can't be combined with WithTrustSocketFiltering.
+func WithBindAddress(ip net.IP) ConsumerOption {
+	return func(o *consumerOptions) error {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return fmt.Errorf("invalid bind address %s: must be an IPv4 address", ip)
+		}
+
+		if !ip4.IsUnspecified() && !ip4.IsMulticast() {
+			return fmt.Errorf("invalid bind address %s: a socket bound to a unicast address receives no multicast datagrams, "+
+				"bind to 0.0.0.0 or the group instead", ip)
+		}
+
+		o.bindAddress = ip4
+
+		return nil
+	}
+}
+
+// WithTap writes a framed record of every received packet to w before the
+// callback is invoked. Records can be read back with ReadTapRecord, e.g. to
+// replay a session with Consumer.Inject. Write errors are ignored.
+func WithTap(w io.Writer) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.tap = &tap{w: w, encode: encodeTapRecord}
+
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report events such as rejoins. By
+// default, nothing is logged.
+func WithLogger(logger *slog.Logger) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.logger = logger
+
+		return nil
+	}
+}
+
+// WithName labels the consumer, for example "primary-audio", to tell apart
+// consumers of the same group. The name is attached to every log record as
+// the "consumer" attribute, reported in IfaceStat and included in String.
+func WithName(name string) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.name = name
+
+		return nil
+	}
+}
+
+// WithRejoinOnSilence leaves and rejoins the group on an interface that
+// hasn't received anything for d since its last packet or join. This
+// recovers reception after the network silently dropped the membership, as
+// happens on Wi-Fi when roaming between access points. Use Consumer.Rejoin
+// to react to link changes immediately.
+func WithRejoinOnSilence(d time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if d < 0 {
+			return fmt.Errorf("invalid silence period %s", d)
+		}
+
+		o.rejoinSilence = d
+
+		return nil
+	}
+}
+
+// WithPacketCallback delivers packets to cb instead of the consumer's regular
+// callback. The Packet carries all metadata available for the packet, and
+// new metadata is added to it rather than to the callback's parameters.
+func WithPacketCallback(cb PacketCallback) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.packetCb = cb
+
+		return nil
+	}
+}
+
+// WithECN requests the IP type of service byte of every datagram from the
+// kernel, making Packet.TOS and Packet.ECN available and counting congestion
+// experienced marks in Stats.CongestionExperienced. Only supported on Linux.
+func WithECN() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.ecn = true
+
+		return nil
+	}
+}
+
+// WithPacketInfo requests IP_PKTINFO for every datagram, which reports the
+// exact destination address along with the index of the interface the
+// datagram arrived on, as Packet.Dst and Packet.IfIndex, also when the
+// destination isn't checked with WithTrustSocketFiltering.
+func WithPacketInfo() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.packetInfo = true
+
+		return nil
+	}
+}
+
+// WithControlMessage requests every control message the ipv4 package can
+// parse for every datagram, and hands it to the callback as
+// Packet.ControlMessage, for callbacks that need fields the Packet doesn't
+// carry on its own.
+func WithControlMessage() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.controlMessage = true
+
+		return nil
+	}
+}
+
+// WithMaxQueuedBytes limits the payload bytes waiting in the queue between
+// the read loops and the callback. Packets that would exceed the limit are
+// dropped and counted in Stats.QueueDrops. This requires a queued delivery
+// mode such as WithSerialDelivery or WithWorkerPool.
+func WithMaxQueuedBytes(n int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if n < 0 {
+			return fmt.Errorf("invalid queued bytes limit %d", n)
+		}
+
+		o.maxQueuedBytes = n
+
+		return nil
+	}
+}
+
+// WithDrainOnClose makes Close deliver the packets that were already read
+// and queued before it returns, instead of discarding them. Reading stops as
+// usual. This requires a queued delivery mode such as WithSerialDelivery or
+// WithWorkerPool, and Close must then not be called from the callback.
+func WithDrainOnClose() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.drainOnClose = true
+
+		return nil
+	}
+}
+
+// WithMulticastAll restores the Linux default of handing each socket the
+// datagrams of all groups joined by any socket on the host, as long as they
+// match its bound address and port (IP_MULTICAST_ALL). By default, sockets
+// only receive the groups joined on them, so that consumers of different
+// groups on the same port don't see each other's traffic. Other platforms
+// ignore this option.
+func WithMulticastAll() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.multicastAll = true
+
+		return nil
+	}
+}
+
+// WithReceiveBuffer sets the receive buffer size of the sockets (SO_RCVBUF)
+// to absorb bursts that the read loops can't keep up with. The kernel may
+// grant less than requested, capped by net.core.rmem_max on Linux.
+func WithReceiveBuffer(bytes int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if bytes < 0 {
+			return fmt.Errorf("invalid receive buffer size %d", bytes)
+		}
+
+		o.receiveBuffer = bytes
+
+		return nil
+	}
+}
+
+// WithCallbackDeadline logs a warning through the consumer's logger when a
+// callback invocation runs longer than d, and another one once it returns,
+// to catch handlers that block the read loops. The callback itself is left
+// running.
+func WithCallbackDeadline(d time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if d < 0 {
+			return fmt.Errorf("invalid callback deadline %s", d)
+		}
+
+		o.callbackDeadline = d
+
+		return nil
+	}
+}
+
+// WithTimestamping requests receive timestamps from the kernel and makes
+// them available as Packet.Timestamp, with Packet.TimestampSource telling
+// where they were taken. On Linux (SO_TIMESTAMPING), hardware timestamps
+// are used where the NIC provides them, which requires hardware
+// timestamping to be enabled on it, e.g. with hwstamp_ctl. Otherwise the
+// kernel's software timestamps are used, as on the BSDs and macOS
+// (SO_TIMESTAMP). Elsewhere, packets are timestamped in userspace right
+// after they are read.
+func WithTimestamping() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.timestamping = true
+
+		return nil
+	}
+}
+
+// WithSequenceGaps tracks the big-endian sequence number of size bytes (1,
+// 2, 4 or 8) found at offset in every payload, separately for each source
+// and interface. Whenever a packet skips ahead of the number following the
+// highest one seen, wrapping around at the width of the number, the gap is
+// counted in Stats.SequenceGaps and reported to onGap, which may be nil.
+// Packets carrying a number at or below the highest one, because they were
+// reordered or duplicated, are counted in Stats.Reordered instead. Packets
+// too short to hold the sequence number are ignored.
+func WithSequenceGaps(offset, size int, onGap SequenceGapCallback) ConsumerOption {
+	return func(o *consumerOptions) error {
+		field, err := newSequenceField(offset, size)
+		if err != nil {
+			return err
+		}
+
+		o.sequence = newSequenceTracker(field, onGap)
+
+		return nil
+	}
+}
+
+// WithLockOSThread locks every read loop to its own OS thread for its whole
+// lifetime, which costs a dedicated thread per socket. Pinning that thread
+// to an isolated CPU, for example with unix.SchedSetaffinity(0, ...) from
+// the first invocation of a directly delivered callback, then gives
+// reception free of scheduling jitter.
+func WithLockOSThread() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.lockOSThread = true
+
+		return nil
+	}
+}
+
+// WithJoinVerification checks the kernel's list of memberships after
+// joining the group on an interface and logs a warning if the membership
+// isn't listed on that interface, as happens on some multi-homed hosts. Only
+// supported on Linux, where /proc/net/igmp is read; elsewhere nothing is
+// verified.
+func WithJoinVerification() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.verifyJoin = true
+
+		return nil
+	}
+}
+
+// WithSubnetCheck logs a warning when creating the consumer if any two of
+// its interfaces have addresses in the same IPv4 subnet. Such interfaces are
+// likely bridged on the same layer 2 network, so every packet of the group
+// would be delivered once per interface and needs to be deduplicated.
+func WithSubnetCheck() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.subnetCheck = true
+
+		return nil
+	}
+}
+
+// WithSkipUnnumbered skips interfaces without an IPv4 address instead of
+// joining the group on them with a warning. Their membership reports would
+// be sent from 0.0.0.0, which some switches drop, so the group often never
+// arrives. Skipped interfaces report ErrNoIPv4Address as their last error.
+func WithSkipUnnumbered() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.skipUnnumbered = true
+
+		return nil
+	}
+}
+
+// WithIgnoreMulticastFlag joins the group on interfaces that don't have
+// net.FlagMulticast set instead of skipping them, for virtual interfaces
+// that support multicast without announcing it. Creating the consumer
+// fails with the join error on interfaces that really don't.
+func WithIgnoreMulticastFlag() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.ignoreMulticastFlag = true
+
+		return nil
+	}
+}
+
+// WithStatsLogInterval logs the consumer's statistics through its logger
+// every d, as a lightweight alternative to exporting them as metrics.
+func WithStatsLogInterval(d time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid stats log interval %s: must be positive", d)
+		}
+
+		o.statsLogInterval = d
+
+		return nil
+	}
+}
+
+// WithRequireJoin makes creating the consumer fail with
+// ErrNoUsableInterfaces if the group couldn't be joined on any interface,
+// for example because none of them supports multicast, instead of
+// returning a consumer that never receives anything. The same applies to
+// Activate with WithLazyStart.
+func WithRequireJoin() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.requireJoin = true
+
+		return nil
+	}
+}
+
+// WithWorkerPool invokes the callback from n goroutines, fed through a
+// queue by the read loops, so that a slow callback doesn't hold up reading.
+// Packets may be delivered concurrently and out of order, even those of the
+// same source, unless WithSourceOrdering is added.
+func WithWorkerPool(n int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if n < 1 {
+			return fmt.Errorf("invalid worker count %d: must be at least 1", n)
+		}
+
+		o.workers = n
+
+		return nil
+	}
+}
+
+// WithSourceOrdering assigns every source address to a fixed worker of the
+// pool set up with WithWorkerPool. The packets of one source are then
+// delivered one at a time, in the order they were read, while packets of
+// different sources are still delivered in parallel. The order in which a
+// source's packets are read is only defined per interface, so packets a
+// source sends over several interfaces may still be reordered between them.
+func WithSourceOrdering() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.sourceOrdering = true
+
+		return nil
+	}
+}
+
+// WithClock replaces the real time as the source of time for the
+// consumer's time-based features.
+func WithClock(clock Clock) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+
+		o.clock = clock
+
+		return nil
+	}
+}
+
+// WithPayloadArena copies the payloads of received packets into slabs of
+// size bytes instead of allocating each one separately, and recycles a slab
+// once every packet carved from it has been delivered. This brings the
+// allocations per packet close to zero at high rates, at the price that a
+// payload is only valid until the callback returns. Callbacks that keep a
+// packet's payload beyond that must call Packet.Retain, which copies it out
+// of the arena.
+func WithPayloadArena(size int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if size < maxMTU {
+			return fmt.Errorf("invalid arena slab size %d: must be at least %d", size, maxMTU)
+		}
+
+		o.arenaSize = size
+
+		return nil
+	}
+}
+
+// WithRedundancy delivers every packet of redundant feeds once, for setups
+// in which several sources, or the same source over several interfaces,
+// send identical streams as with PRP. Packets are identified by the
+// big-endian sequence number of size bytes (1, 2, 4 or 8) found at offset
+// in every payload, and the first packet carrying a number is delivered
+// while later ones are counted in Stats.Duplicates and dropped. The numbers
+// are remembered for the last 1024 of them, or half the range of the
+// number if that is smaller, so a feed lagging further behind is delivered
+// again. Packets too short to hold the sequence number are always
+// delivered.
+func WithRedundancy(offset, size int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		field, err := newSequenceField(offset, size)
+		if err != nil {
+			return err
+		}
+
+		o.redundancy = newRedundancyFilter(field)
+
+		return nil
+	}
+}
+
+// WithBPF attaches the classic BPF program filter to the consumer's sockets,
+// so that the kernel drops the datagrams it rejects before they reach the
+// read loops. The program sees each datagram starting with its 8 byte UDP
+// header, followed by the payload. Only supported on Linux, and not together
+// with WithFanout, which attaches a filter of its own.
+func WithBPF(filter []bpf.RawInstruction) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if len(filter) == 0 {
+			return errors.New("BPF filter must not be empty")
+		}
+
+		o.filter = filter
+
+		return nil
+	}
+}
+
+// WithLazyStart creates the consumer without opening its sockets or joining
+// the group, which is deferred until Consumer.Activate is called. This
+// allows declaring many consumers while only those in use hold kernel
+// memberships.
+func WithLazyStart() ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.lazy = true
+
+		return nil
+	}
+}
+
+func (o *consumerOptions) validate(addr *net.UDPAddr) error {
+	queued := o.serial || o.workers > 0
+
+	if o.serial && o.workers > 0 {
+		return errors.New("serial delivery can't be combined with a worker pool")
+	}
+
+	if o.sourceOrdering && o.workers == 0 {
+		return errors.New("source ordering requires a worker pool")
+	}
+
+	if o.maxQueuedBytes > 0 && !queued {
+		return errors.New("limiting queued bytes requires a queued delivery mode")
+	}
+
+	if o.drainOnClose && !queued {
+		return errors.New("draining on close requires a queued delivery mode")
+	}
+
+	if o.trustSocketFiltering && o.bindAddress != nil && !o.bindAddress.Equal(addr.IP) {
+		return errors.New("socket filtering can't be trusted with a bind address other than the group")
+	}
+
+	if o.filter != nil && o.fanout > 1 {
+		return errors.New("a BPF filter can't be combined with fanout")
+	}
+
+	if o.pool != nil {
+		switch {
+		case addr.Port == 0:
+			return errors.New("an ephemeral port is not supported with shared sockets")
+		case o.fanout > 1:
+			return errors.New("fanout is not supported with shared sockets")
+		case o.bindDevice != "", o.bindAddress != nil:
+			return errors.New("binding is not configurable with shared sockets")
+		case o.trustSocketFiltering:
+			return errors.New("socket filtering can't be trusted with shared sockets")
+		case o.ecn, o.timestamping, o.packetInfo, o.controlMessage:
+			return errors.New("per-packet metadata options are not supported with shared sockets")
+		case o.filter != nil:
+			return errors.New("BPF filters are not supported with shared sockets")
+		}
+	}
+
+	return nil
+}
+
+// controlFlags returns the control messages to request beyond the
+// destination needed to check it, if any.
+func (o *consumerOptions) controlFlags() ipv4.ControlFlags {
+	switch {
+	case o.controlMessage:
+		return ipv4.FlagTTL | ipv4.FlagSrc | ipv4.FlagDst | ipv4.FlagInterface
+	case o.packetInfo:
+		return ipv4.FlagDst | ipv4.FlagInterface
+	default:
+		return 0
+	}
+}
+
+// callback resolves the callback packets are delivered to.
+func (o *consumerOptions) callback(cb ConsumerPacketCallback) PacketCallback {
+	switch {
+	case o.packetCb != nil:
+		return o.packetCb
+
+	case o.groupCb != nil:
+		groupCb := o.groupCb
+
+		return func(pkt *Packet) {
+			groupCb(pkt.Interface, pkt.Src, pkt.Dst, pkt.Payload)
+		}
+
+	case o.batch != nil:
+		o.batch.clock = o.clock
+
+		return o.batch.add
+
+	default:
+		return AdaptCallback(cb)
+	}
+}
+
+// WithExpectedSourcePort drops the datagrams not sent from port, counting
+// them in Stats.SourcePortMismatches. This keeps unrelated traffic that
+// reaches the group and port from another application away from the
+// callback, when the senders are known to use a fixed source port.
+func WithExpectedSourcePort(port int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid source port %d", port)
+		}
+
+		o.expectedSourcePort = port
+
+		return nil
+	}
+}
+
+// WithAdaptiveReadBuffer grows the read buffers whenever a datagram is
+// truncated, up to limit bytes, so that the following datagrams of that size
+// fit. A truncated datagram can't be read again, but feeds whose datagram
+// size isn't known in advance settle after the first one. Where the real
+// size of a truncated datagram isn't reported, the buffers grow to the limit
+// right away.
+func WithAdaptiveReadBuffer(limit int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if limit < maxMTU || limit > maxDatagram {
+			return fmt.Errorf("invalid read buffer limit %d: must be between %d and %d", limit, maxMTU, maxDatagram)
+		}
+
+		o.readBufferLimit = limit
+
+		return nil
+	}
+}
+
+// WithOnClose calls fn exactly once when the consumer has stopped, with the
+// reason StopReason reports. It is called from a goroutine of its own once
+// every read loop and worker has exited, so it may release resources the
+// callback uses, and the consumer may also be closed from the callback.
+func WithOnClose(fn func(reason error)) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.onClose = fn
+
+		return nil
+	}
+}
+
+// WithMaxCaptureBytes hands at most the first n bytes of every datagram to
+// the callback, for example to parse a fixed-size header only, and copies
+// no more than that. The rest of the datagram is discarded with the read.
+// Packet.Length still reports the full size, and unlike a datagram that
+// didn't fit the read buffer, the packet isn't marked Truncated.
+func WithMaxCaptureBytes(n int) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if n < 1 {
+			return fmt.Errorf("invalid capture size %d: must be at least 1", n)
+		}
+
+		o.maxCaptureBytes = n
+
+		return nil
+	}
+}
+
+// WithJoinRetry keeps the consumer alive when opening its socket or joining
+// the group on an interface fails because the network or the interface
+// isn't up yet, as reported by ErrNetworkUnavailable, and retries every
+// interval until it succeeds. The failure is reported in IfaceStat.LastError
+// meanwhile. This lets a consumer be created while the system is still
+// booting, while other join failures still fail NewConsumer. With
+// WithRequireJoin, at least one interface must be joined right away
+// nonetheless.
+func WithJoinRetry(interval time.Duration) ConsumerOption {
+	return func(o *consumerOptions) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid join retry interval %s: must be positive", interval)
+		}
+
+		o.joinRetryInterval = interval
+
+		return nil
+	}
+}
+
+// WithValidator drops the packets whose payload fails validate, for example
+// a length or checksum check of the protocol, before they reach the callback
+// or any per-packet tracking, and counts them in Stats.Invalid. validate is
+// called from the goroutine reading the packet, with the payload as cut
+// short by WithMaxCaptureBytes, and must not keep it.
+func WithValidator(validate func(payload []byte) bool) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.validator = validate
+
+		return nil
+	}
+}
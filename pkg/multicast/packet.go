@@ -0,0 +1,99 @@
+package multicast
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Packet is a received datagram along with its metadata.
+type Packet struct {
+	// Interface is the interface the packet was received on
+	Interface *net.Interface
+
+	// Src is the sender's address
+	Src net.Addr
+
+	// Dst is the group and port the packet was sent to
+	Dst *net.UDPAddr
+
+	Payload []byte
+
+	// Length is the size of the datagram as read. It only differs from the
+	// length of Payload if WithMaxCaptureBytes cut the payload short.
+	Length int
+
+	// Truncated is set when the datagram didn't fit into the read buffer
+	// and Payload only holds its beginning. The buffer holds the largest
+	// MTU of the consumer's interfaces, and at least a full Ethernet MTU,
+	// so a truncated datagram was necessarily fragmented on the way, which
+	// hints at path MTU problems. See IPReassemblyStats.
+	Truncated bool
+
+	// TOS is the IP type of service byte, including the ECN bits. Only set
+	// if HasTOS is, which requires the WithECN option.
+	TOS    byte
+	HasTOS bool
+
+	// Timestamp is the time the packet was received, taken where
+	// TimestampSource tells. HardwareTimestamp is set along with
+	// TimestampHardware. Only set with the WithTimestamping option.
+	Timestamp         time.Time
+	HardwareTimestamp bool
+	TimestampSource   TimestampSource
+
+	// IfIndex is the index of the interface the datagram arrived on, as
+	// reported by the kernel. It may differ from Interface, whose socket
+	// also receives the group's datagrams arriving on other interfaces the
+	// group is joined on. Only set with the WithPacketInfo or
+	// WithControlMessage option.
+	IfIndex int
+
+	// ControlMessage is the datagram's complete parsed control message,
+	// with the TTL, source, destination and interface index. Only set with
+	// the WithControlMessage option.
+	ControlMessage *ipv4.ControlMessage
+
+	// The arena slab Payload was carved from, if any
+	slab *slab
+
+	// The bytes a truncated datagram read from a shared socket lost, for the
+	// consumer it is delivered to to count
+	truncatedBytes int
+}
+
+// ECN is the explicit congestion notification codepoint of a packet
+type ECN uint8
+
+const (
+	ECNNotECT ECN = 0
+	ECNECT1   ECN = 1
+	ECNECT0   ECN = 2
+	ECNCE     ECN = 3
+)
+
+func (e ECN) String() string {
+	switch e {
+	case ECNNotECT:
+		return "Not-ECT"
+	case ECNECT1:
+		return "ECT(1)"
+	case ECNECT0:
+		return "ECT(0)"
+	default:
+		return "CE"
+	}
+}
+
+// ECN returns the packet's ECN codepoint from the low two bits of TOS.
+func (p *Packet) ECN() ECN {
+	return ECN(p.TOS & 0x3)
+}
+
+// AdaptCallback wraps a ConsumerPacketCallback into a PacketCallback.
+func AdaptCallback(cb ConsumerPacketCallback) PacketCallback {
+	return func(pkt *Packet) {
+		cb(pkt.Interface, pkt.Src, pkt.Payload)
+	}
+}
@@ -0,0 +1,198 @@
+package multicast
+
+import (
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PacketConn adapts a consumer to net.PacketConn, for code that reads
+// datagrams from a net.PacketConn. ReadFrom returns the datagrams received
+// on the group, and WriteTo sends through the consumer's sockets, once per
+// interface.
+type PacketConn struct {
+	consumer *Consumer
+	packets  chan *Packet
+	done     chan struct{}
+
+	closeOnce sync.Once
+
+	mutex         sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// Closed and replaced whenever the read deadline changes
+	deadlineChanged chan struct{}
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+// NewPacketConn joins the group addr on ifis and returns it as a
+// net.PacketConn. The options are those of NewConsumer, except for the
+// callbacks.
+func NewPacketConn(addr *net.UDPAddr, ifis []*net.Interface, opts ...ConsumerOption) (*PacketConn, error) {
+	p := &PacketConn{
+		packets: make(chan *Packet, queueSize),
+		done:    make(chan struct{}),
+
+		deadlineChanged: make(chan struct{}),
+	}
+
+	cb := func(pkt *Packet) {
+		pkt.Retain()
+
+		select {
+		case p.packets <- pkt:
+		case <-p.done:
+		}
+	}
+
+	consumer, err := NewConsumer(addr, ifis, nil, append(slices.Clip(opts), WithPacketCallback(cb))...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.consumer = consumer
+
+	return p, nil
+}
+
+// Consumer returns the underlying consumer, e.g. for its statistics.
+// Closing it fails reads with net.ErrClosed, like Close.
+func (p *PacketConn) Consumer() *Consumer {
+	return p.consumer
+}
+
+// ReadFrom reads the next datagram received on the group. As with UDP
+// sockets, the rest of a datagram that doesn't fit into b is discarded.
+func (p *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		p.mutex.Lock()
+		deadline := p.readDeadline
+		changed := p.deadlineChanged
+		p.mutex.Unlock()
+
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, nil, p.opError("read", os.ErrDeadlineExceeded)
+			}
+
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		select {
+		case pkt := <-p.packets:
+			return copy(b, pkt.Payload), pkt.Src, nil
+
+		case <-p.done:
+			return 0, nil, p.opError("read", net.ErrClosed)
+
+		case <-p.consumer.done:
+			return 0, nil, p.opError("read", net.ErrClosed)
+
+		case <-timeout:
+			return 0, nil, p.opError("read", os.ErrDeadlineExceeded)
+
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// WriteTo sends b to addr through the socket of every interface of the
+// consumer, returning the first error.
+func (p *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-p.done:
+		return 0, p.opError("write", net.ErrClosed)
+	default:
+	}
+
+	p.mutex.Lock()
+	deadline := p.writeDeadline
+	p.mutex.Unlock()
+
+	p.consumer.mutex.Lock()
+	conns := make([]*net.UDPConn, 0, len(p.consumer.ifaces))
+
+	for _, is := range p.consumer.ifaces {
+		if sockets := is.allSockets(); len(sockets) > 0 {
+			conns = append(conns, sockets[0].conn)
+		}
+	}
+	p.consumer.mutex.Unlock()
+
+	if len(conns) == 0 {
+		return 0, p.opError("write", net.ErrClosed)
+	}
+
+	for _, conn := range conns {
+		if err := conn.SetWriteDeadline(deadline); err != nil {
+			return 0, err
+		}
+
+		if _, err := conn.WriteTo(b, addr); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (p *PacketConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.consumer.Close()
+	})
+
+	return nil
+}
+
+func (p *PacketConn) LocalAddr() net.Addr {
+	return p.consumer.Address()
+}
+
+func (p *PacketConn) SetDeadline(t time.Time) error {
+	if err := p.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return p.SetWriteDeadline(t)
+}
+
+func (p *PacketConn) SetReadDeadline(t time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.readDeadline = t
+
+	// Wake up blocked reads to pick up the new deadline
+	close(p.deadlineChanged)
+	p.deadlineChanged = make(chan struct{})
+
+	return nil
+}
+
+func (p *PacketConn) SetWriteDeadline(t time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.writeDeadline = t
+
+	return nil
+}
+
+func (p *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: p.consumer.Address(), Err: err}
+}
@@ -0,0 +1,109 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// Packets are written to pcap files as raw IPv4 datagrams, with IPv4 and UDP
+// headers synthesized from the packet's metadata, and nanosecond timestamps.
+// All integers of the pcap framing are little-endian, those of the headers
+// in network byte order.
+const (
+	pcapMagicNanoseconds = 0xa1b23c4d
+	pcapLinkTypeRaw      = 101
+	pcapFileHeaderLen    = 24
+	pcapRecordHeaderLen  = 16
+
+	ipv4HeaderLen = 20
+)
+
+// WithPcapTap writes every received packet to w in the pcap format before
+// the callback is invoked, like WithTap, so a session can be opened in
+// Wireshark or tcpdump. The datagrams are recorded without a link layer,
+// with IPv4 and UDP headers rebuilt from the packet's metadata, and stamped
+// with the packet's Timestamp if WithTimestamping is set, or the time it was
+// delivered otherwise. The file header is written once the consumer has
+// started, and NewConsumer fails if that write fails. Write errors on the
+// packets are ignored.
+func WithPcapTap(w io.Writer) ConsumerOption {
+	return func(o *consumerOptions) error {
+		header := make([]byte, pcapFileHeaderLen)
+
+		binary.LittleEndian.PutUint32(header[0:4], pcapMagicNanoseconds)
+		binary.LittleEndian.PutUint16(header[4:6], 2)
+		binary.LittleEndian.PutUint16(header[6:8], 4)
+		binary.LittleEndian.PutUint32(header[16:20], maxDatagram)
+		binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeRaw)
+
+		o.tap = &tap{w: w, encode: encodePcapRecord, header: header}
+
+		return nil
+	}
+}
+
+// encodePcapRecord frames a packet received at ts as a pcap record holding
+// an IPv4 datagram. The headers carry the datagram's original length, so a
+// payload cut short by WithMaxCaptureBytes shows up as a partial capture.
+func encodePcapRecord(pkt *Packet, ts time.Time) []byte {
+	length := ipv4HeaderLen + udpHeaderLen + len(pkt.Payload)
+	origLength := ipv4HeaderLen + udpHeaderLen + max(pkt.Length, len(pkt.Payload))
+	record := make([]byte, pcapRecordHeaderLen+length)
+
+	binary.LittleEndian.PutUint32(record[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(length))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(origLength))
+
+	var (
+		srcIP   net.IP
+		srcPort int
+	)
+
+	if udpSrc, ok := pkt.Src.(*net.UDPAddr); ok {
+		srcIP, srcPort = udpSrc.IP.To4(), udpSrc.Port
+	}
+
+	// Multicast is sent with a TTL of 1 unless known otherwise
+	ttl := byte(1)
+	if cm := pkt.ControlMessage; cm != nil && cm.TTL > 0 {
+		ttl = byte(cm.TTL)
+	}
+
+	ip := record[pcapRecordHeaderLen:]
+	ip[0] = 0x45
+	ip[1] = pkt.TOS
+	binary.BigEndian.PutUint16(ip[2:4], uint16(origLength))
+	ip[8] = ttl
+	ip[9] = ipProtocolUDP
+	copy(ip[12:16], srcIP)
+	copy(ip[16:20], pkt.Dst.IP.To4())
+	binary.BigEndian.PutUint16(ip[10:12], ipv4Checksum(ip[:ipv4HeaderLen]))
+
+	// The UDP checksum is optional over IPv4 and left out
+	udp := ip[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(pkt.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(origLength-ipv4HeaderLen))
+	copy(udp[udpHeaderLen:], pkt.Payload)
+
+	return record
+}
+
+// ipv4Checksum returns the checksum of an IPv4 header whose checksum field
+// is zero.
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
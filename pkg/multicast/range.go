@@ -0,0 +1,172 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/bpf"
+)
+
+const (
+	ipProtocolUDP = 17
+	udpHeaderLen  = 8
+)
+
+var (
+	multicastNet = &net.IPNet{IP: net.IPv4(224, 0, 0, 0).To4(), Mask: net.CIDRMask(4, 32)}
+)
+
+// RangeConsumer receives UDP datagrams sent to any group within an IPv4
+// prefix without joining the groups individually. It captures the traffic
+// below the UDP layer and relies on the network delivering it to the
+// interface anyway, e.g. through switch flooding or a mirror port. Only
+// supported on Linux, and requires CAP_NET_RAW.
+type RangeConsumer struct {
+	prefix  *net.IPNet
+	port    int
+	ifis    []*net.Interface
+	cb      ConsumerGroupPacketCallback
+	sockets []*rangeSocket
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// NewRangeConsumer starts capturing datagrams sent to any group in prefix on
+// the given interfaces. If port is not 0, only datagrams sent to that
+// destination port are delivered. The callback receives the actual group
+// each datagram was sent to.
+func NewRangeConsumer(prefix *net.IPNet, port int, ifis []*net.Interface, cb ConsumerGroupPacketCallback) (*RangeConsumer, error) {
+	ip := prefix.IP.To4()
+	if ip == nil || !multicastNet.Contains(ip) {
+		return nil, fmt.Errorf("prefix %s is not an IPv4 multicast range", prefix.String())
+	}
+
+	if ones, bits := prefix.Mask.Size(); bits != 32 || ones < 4 {
+		return nil, fmt.Errorf("prefix %s is not an IPv4 multicast range", prefix.String())
+	}
+
+	if port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+
+	rc := &RangeConsumer{
+		prefix: &net.IPNet{IP: ip.Mask(prefix.Mask), Mask: prefix.Mask},
+		port:   port,
+		ifis:   ifis,
+		cb:     cb,
+	}
+
+	for _, ifi := range ifis {
+		s, err := rc.openSocket(ifi)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("failed to open capture socket on interface %s: %w", ifi.Name, err)
+		}
+
+		rc.sockets = append(rc.sockets, s)
+
+		go rc.readLoop(s)
+	}
+
+	return rc, nil
+}
+
+func (rc *RangeConsumer) Close() {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.closed {
+		return
+	}
+
+	rc.closed = true
+
+	for _, s := range rc.sockets {
+		s.close()
+	}
+
+	rc.sockets = nil
+}
+
+func (rc *RangeConsumer) Prefix() *net.IPNet {
+	return rc.prefix
+}
+
+func (rc *RangeConsumer) Interfaces() []*net.Interface {
+	return rc.ifis
+}
+
+// filter matches non-fragmented or first-fragment UDP datagrams whose
+// destination is within the prefix and, if set, matches the port.
+func (rc *RangeConsumer) filter() ([]bpf.RawInstruction, error) {
+	mask := binary.BigEndian.Uint32(rc.prefix.Mask)
+	network := binary.BigEndian.Uint32(rc.prefix.IP.To4())
+
+	var portCheck []bpf.Instruction
+	if rc.port != 0 {
+		portCheck = []bpf.Instruction{
+			bpf.LoadMemShift{Off: 0},
+			bpf.LoadIndirect{Off: 2, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(rc.port), SkipTrue: 1},
+		}
+	}
+
+	// Number of instructions to skip to reach the final drop
+	toDrop := func(remaining int) uint8 {
+		return uint8(remaining + len(portCheck) + 1)
+	}
+
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: ipProtocolUDP, SkipTrue: toDrop(5)},
+		bpf.LoadAbsolute{Off: 6, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: toDrop(3)},
+		bpf.LoadAbsolute{Off: 16, Size: 4},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: network, SkipTrue: toDrop(0)},
+	}
+
+	prog = append(prog, portCheck...)
+	prog = append(prog,
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	)
+
+	return bpf.Assemble(prog)
+}
+
+// parseUDPv4 extracts the addresses and a copy of the payload from an IPv4
+// datagram carrying UDP.
+func parseUDPv4(b []byte) (*net.UDPAddr, *net.UDPAddr, []byte, bool) {
+	if len(b) < 20 || b[0]>>4 != 4 || b[9] != ipProtocolUDP {
+		return nil, nil, nil, false
+	}
+
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < 20 || len(b) < ihl+udpHeaderLen {
+		return nil, nil, nil, false
+	}
+
+	udp := b[ihl:]
+	end := int(binary.BigEndian.Uint16(udp[4:6]))
+	if end < udpHeaderLen || end > len(udp) {
+		end = len(udp)
+	}
+
+	src := &net.UDPAddr{
+		IP:   net.IPv4(b[12], b[13], b[14], b[15]),
+		Port: int(binary.BigEndian.Uint16(udp[0:2])),
+	}
+
+	dst := &net.UDPAddr{
+		IP:   net.IPv4(b[16], b[17], b[18], b[19]),
+		Port: int(binary.BigEndian.Uint16(udp[2:4])),
+	}
+
+	payload := make([]byte, end-udpHeaderLen)
+	copy(payload, udp[udpHeaderLen:end])
+
+	return src, dst, payload, true
+}
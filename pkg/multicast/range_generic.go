@@ -0,0 +1,18 @@
+//go:build !linux
+
+package multicast
+
+import (
+	"errors"
+	"net"
+)
+
+type rangeSocket struct{}
+
+func (rc *RangeConsumer) openSocket(_ *net.Interface) (*rangeSocket, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (rc *RangeConsumer) readLoop(_ *rangeSocket) {}
+
+func (s *rangeSocket) close() {}
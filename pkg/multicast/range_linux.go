@@ -0,0 +1,164 @@
+//go:build linux
+
+package multicast
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+type rangeSocket struct {
+	ifi  *net.Interface
+	file *os.File
+}
+
+func (rc *RangeConsumer) openSocket(ifi *net.Interface) (*rangeSocket, error) {
+	filter, err := rc.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	// Cooked packet socket, so reads start at the IP header
+	f, err := openPacketSocket(ifi, unix.SOCK_DGRAM, unix.ETH_P_IP, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeSocket{ifi: ifi, file: f}, nil
+}
+
+func (rc *RangeConsumer) readLoop(s *rangeSocket) {
+	readPacketSocket(s.file, func(packet []byte) {
+		src, dst, payload, ok := parseUDPv4(packet)
+		if !ok || !rc.prefix.Contains(dst.IP) {
+			return
+		}
+
+		rc.cb(s.ifi, src, dst, payload)
+	})
+}
+
+func (s *rangeSocket) close() {
+	_ = s.file.Close()
+}
+
+// openPacketSocket opens an AF_PACKET socket bound to ifi, which only sees
+// frames matching filter. The NIC is told to accept frames sent to the
+// multicast MAC address mac, or put in all-multicast mode if mac is nil so
+// that it doesn't discard groups nobody joined.
+func openPacketSocket(ifi *net.Interface, sockType int, proto uint16, filter []bpf.RawInstruction, mac net.HardwareAddr) (*os.File, error) {
+	// Open with protocol 0 so no frames are queued before the filter is in place
+	s, err := unix.Socket(unix.AF_PACKET, sockType|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create packet socket: %w", err)
+	}
+
+	if len(filter) > 0 {
+		prog := unix.SockFprog{
+			Len:    uint16(len(filter)),
+			Filter: (*unix.SockFilter)(unsafe.Pointer(&filter[0])),
+		}
+
+		if err := unix.SetsockoptSockFprog(s, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+			_ = unix.Close(s)
+
+			return nil, fmt.Errorf("failed to attach filter: %w", err)
+		}
+	}
+
+	mreq := unix.PacketMreq{
+		Ifindex: int32(ifi.Index),
+		Type:    unix.PACKET_MR_ALLMULTI,
+	}
+
+	if mac != nil {
+		mreq.Type = unix.PACKET_MR_MULTICAST
+		mreq.Alen = uint16(copy(mreq.Address[:], mac))
+	}
+
+	if err := unix.SetsockoptPacketMreq(s, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		_ = unix.Close(s)
+
+		return nil, fmt.Errorf("failed to add packet membership: %w", err)
+	}
+
+	lla := unix.SockaddrLinklayer{
+		Protocol: htons(proto),
+		Ifindex:  ifi.Index,
+	}
+
+	if err := unix.Bind(s, &lla); err != nil {
+		_ = unix.Close(s)
+
+		return nil, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+
+	return os.NewFile(uintptr(s), "packet:"+ifi.Name), nil
+}
+
+// Bounds of the pause after a failed read of a packet socket
+const (
+	packetReadBackoffMin = 10 * time.Millisecond
+	packetReadBackoffMax = time.Second
+)
+
+// readPacketSocket reads f until it is closed and hands every frame but our
+// own outgoing traffic to handle, in a buffer that is reused afterwards.
+// Failed reads are retried after a pause doubling up to a second, so that a
+// socket that keeps failing doesn't spin.
+func readPacketSocket(f *os.File, handle func(frame []byte)) {
+	buf := make([]byte, 0xffff)
+
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var backoff time.Duration
+
+	for {
+		var (
+			n       int
+			from    unix.Sockaddr
+			readErr error
+		)
+
+		err := rawConn.Read(func(fd uintptr) bool {
+			n, from, readErr = unix.Recvfrom(int(fd), buf, 0)
+			return !errors.Is(readErr, unix.EAGAIN)
+		})
+		if err != nil {
+			// The file was closed
+			return
+		}
+
+		if readErr != nil {
+			if !errors.Is(readErr, unix.EINTR) {
+				backoff = min(max(2*backoff, packetReadBackoffMin), packetReadBackoffMax)
+				time.Sleep(backoff)
+			}
+
+			continue
+		}
+
+		backoff = 0
+
+		// Packet sockets also see our own outgoing traffic
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+
+		handle(buf[:n])
+	}
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,63 @@
+package multicast
+
+import (
+	"sync"
+)
+
+// redundancyWindow is the number of recent sequence numbers remembered to
+// recognize duplicates.
+const redundancyWindow = 1024
+
+// redundancyFilter lets the first packet carrying a sequence number through,
+// whichever source or interface it arrived from.
+type redundancyFilter struct {
+	field sequenceField
+
+	mutex sync.Mutex
+	seen  map[uint64]struct{}
+
+	// The remembered numbers in the order they were seen, as a ring
+	recent []uint64
+	next   int
+}
+
+func newRedundancyFilter(field sequenceField) *redundancyFilter {
+	window := redundancyWindow
+	if field.size < 8 {
+		window = min(window, 1<<(8*field.size-1))
+	}
+
+	return &redundancyFilter{
+		field:  field,
+		seen:   make(map[uint64]struct{}, window),
+		recent: make([]uint64, 0, window),
+	}
+}
+
+// accept reports whether the packet is the first one carrying its sequence
+// number.
+func (f *redundancyFilter) accept(pkt *Packet) bool {
+	seq, ok := f.field.read(pkt.Payload)
+	if !ok {
+		return true
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, dup := f.seen[seq]; dup {
+		return false
+	}
+
+	if len(f.recent) < cap(f.recent) {
+		f.recent = append(f.recent, seq)
+	} else {
+		delete(f.seen, f.recent[f.next])
+		f.recent[f.next] = seq
+		f.next = (f.next + 1) % len(f.recent)
+	}
+
+	f.seen[seq] = struct{}{}
+
+	return true
+}
@@ -0,0 +1,175 @@
+package multicast
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Rejoin leaves and rejoins the group on every interface. This re-arms the
+// membership after events that silently break it, such as roaming between
+// wireless access points, and can be called from a link-change notification.
+func (c *Consumer) Rejoin() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var err error
+
+	for _, is := range c.ifaces {
+		if rerr := c.rejoinInterface(is, "requested"); rerr != nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+// Reset closes and reopens the sockets of every interface and joins the
+// group on them again, keeping the consumer's statistics. This restores
+// reception when the sockets stopped working without reporting an error, for
+// example after the system woke up from sleep. Interfaces that fail to start
+// are reported in the returned error, while the others are reset anyway.
+func (c *Consumer) Reset() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	if c.inactive {
+		return nil
+	}
+
+	var errs []error
+
+	for _, is := range c.ifaces {
+		c.stopInterface(is)
+
+		if err := c.startInterface(is); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Consumer) rejoinInterface(is *ifaceState, reason string) error {
+	pcs := is.packetConns()
+	if len(pcs) == 0 {
+		return nil
+	}
+
+	c.opts.logger.Info("rejoining multicast group",
+		"group", c.Address().String(),
+		"interface", is.ifi.Name,
+		"reason", reason)
+
+	for _, pc := range pcs {
+		// Leaving fails if the kernel already dropped the membership
+		_ = pc.LeaveGroup(is.ifi, c.Address())
+
+		if err := c.joinGroup(pc, is.ifi); err != nil {
+			err = c.joinError(is.ifi, err)
+			is.setError(err)
+			is.joined.Store(false)
+
+			c.opts.logger.Warn("failed to rejoin multicast group",
+				"group", c.Address().String(),
+				"interface", is.ifi.Name,
+				"error", err)
+
+			return err
+		}
+	}
+
+	now := c.opts.clock.Now().UnixNano()
+
+	is.joined.Store(true)
+	is.rejoins.Add(1)
+	is.lastJoin.Store(now)
+
+	if reason == "silence" {
+		is.silenceRejoin.Store(now)
+	}
+
+	return nil
+}
+
+// pruningWindow is how soon after a rejoin due to silence traffic has to
+// resume to suggest that the rejoin, rather than a sender starting up,
+// restored it.
+const pruningWindow = time.Second
+
+// checkPruning flags the interface as likely pruned by a switch if traffic
+// resumed right after a rejoin due to silence. The switch then most likely
+// stopped forwarding the group because no IGMP querier refreshed the
+// membership, while a missing sender wouldn't have been brought back by
+// the rejoin.
+func (c *Consumer) checkPruning(is *ifaceState, sinceRejoin time.Duration) {
+	if sinceRejoin > pruningWindow {
+		return
+	}
+
+	if !is.suspectedPruning.Swap(true) {
+		c.opts.logger.Warn("multicast traffic resumed right after rejoining, suspecting pruning by a switch",
+			"group", c.Address().String(),
+			"interface", is.ifi.Name,
+			"after", sinceRejoin)
+	}
+}
+
+// packetConns returns the connections the group is joined on.
+func (is *ifaceState) packetConns() []*ipv4.PacketConn {
+	sockets := is.allSockets()
+	pcs := make([]*ipv4.PacketConn, 0, len(sockets))
+
+	for _, s := range sockets {
+		pcs = append(pcs, s.pc)
+	}
+
+	return pcs
+}
+
+// allSockets returns the interface's own sockets along with the shared one.
+func (is *ifaceState) allSockets() []*socket {
+	sockets := is.sockets
+
+	if is.shared != nil {
+		sockets = append(slices.Clip(sockets), &is.shared.socket)
+	}
+
+	return sockets
+}
+
+// silenceMonitor rejoins the group on interfaces that haven't received a
+// packet for the configured silence period since the last packet or join.
+func (c *Consumer) silenceMonitor() {
+	period := c.opts.rejoinSilence
+
+	ticker := c.opts.clock.NewTicker(period / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case now := <-ticker.C():
+			c.mutex.Lock()
+
+			for _, is := range c.ifaces {
+				last := max(is.lastPacket.Load(), is.lastJoin.Load())
+
+				if now.Sub(time.Unix(0, last)) >= period {
+					_ = c.rejoinInterface(is, "silence")
+				}
+			}
+
+			c.mutex.Unlock()
+		}
+	}
+}
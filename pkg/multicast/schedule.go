@@ -0,0 +1,47 @@
+package multicast
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// NewScheduledConsumer creates a consumer that joins the group at start and
+// is closed at end. Until start, it holds no sockets as with WithLazyStart,
+// and a start in the past joins right away. The times are measured by the
+// clock set with WithClock. A failure to join at start is logged, and
+// Activate may be called to retry.
+func NewScheduledConsumer(addr *net.UDPAddr, ifis []*net.Interface, cb ConsumerPacketCallback, start, end time.Time, opts ...ConsumerOption) (*Consumer, error) {
+	if !end.After(start) {
+		return nil, fmt.Errorf("schedule end %s is not after its start %s", end, start)
+	}
+
+	c, err := NewConsumer(addr, ifis, cb, append(slices.Clip(opts), WithLazyStart())...)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.opts.clock.Now()
+
+	if !end.After(now) {
+		c.Close()
+		return nil, fmt.Errorf("schedule already ended at %s", end)
+	}
+
+	startTimer := c.opts.clock.AfterFunc(start.Sub(now), func() {
+		if err := c.Activate(); err != nil && !c.isClosed() {
+			c.opts.logger.Warn("failed to join multicast group at scheduled start",
+				"group", c.Address().String(),
+				"error", err)
+		}
+	})
+
+	endTimer := c.opts.clock.AfterFunc(end.Sub(now), c.Close)
+
+	c.mutex.Lock()
+	c.schedule = []Timer{startTimer, endTimer}
+	c.mutex.Unlock()
+
+	return c, nil
+}
@@ -0,0 +1,128 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// SequenceGapCallback is invoked when a packet's sequence number skips ahead
+// of the one following the highest seen from the same source.
+type SequenceGapCallback func(src net.Addr, expected, got uint64)
+
+// sequenceField locates a big-endian sequence number in the payloads.
+type sequenceField struct {
+	offset int
+	size   int
+}
+
+func newSequenceField(offset, size int) (sequenceField, error) {
+	if offset < 0 {
+		return sequenceField{}, fmt.Errorf("invalid sequence number offset %d", offset)
+	}
+
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return sequenceField{}, fmt.Errorf("invalid sequence number size %d: must be 1, 2, 4 or 8", size)
+	}
+
+	return sequenceField{offset: offset, size: size}, nil
+}
+
+// sequenceTracker follows the sequence numbers found at a fixed offset in
+// the payloads, per interface and source.
+type sequenceTracker struct {
+	sequenceField
+	onGap SequenceGapCallback
+
+	mutex   sync.Mutex
+	highest map[sequenceKey]uint64
+}
+
+type sequenceKey struct {
+	ifindex int
+	src     string
+}
+
+func newSequenceTracker(field sequenceField, onGap SequenceGapCallback) *sequenceTracker {
+	return &sequenceTracker{
+		sequenceField: field,
+		onGap:         onGap,
+		highest:       make(map[sequenceKey]uint64),
+	}
+}
+
+// read extracts the big-endian sequence number from the payload.
+func (f sequenceField) read(payload []byte) (uint64, bool) {
+	if len(payload) < f.offset+f.size {
+		return 0, false
+	}
+
+	b := payload[f.offset : f.offset+f.size]
+
+	switch f.size {
+	case 1:
+		return uint64(b[0]), true
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), true
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), true
+	default:
+		return binary.BigEndian.Uint64(b), true
+	}
+}
+
+// sequenceResult is how a packet's sequence number relates to those seen
+// before from the same source.
+type sequenceResult int
+
+const (
+	sequenceInOrder sequenceResult = iota
+	sequenceGap
+	sequenceReordered
+)
+
+// check records the packet's sequence number and reports whether it
+// continues the source's sequence, skips ahead of it, or falls behind the
+// highest number seen. Numbers compare in serial number arithmetic, so up to
+// half the range ahead of the highest one counts as ahead even across the
+// wrap around. The first packet of a source is always in order.
+func (t *sequenceTracker) check(is *ifaceState, pkt *Packet) sequenceResult {
+	seq, ok := t.read(pkt.Payload)
+	if !ok || pkt.Src == nil {
+		return sequenceInOrder
+	}
+
+	key := sequenceKey{ifindex: is.ifi.Index, src: pkt.Src.String()}
+
+	mask := ^uint64(0)
+	if t.size < 8 {
+		mask = 1<<(8*t.size) - 1
+	}
+
+	t.mutex.Lock()
+
+	highest, seen := t.highest[key]
+	ahead := (seq - highest) & mask
+
+	if seen && (ahead == 0 || ahead > mask/2) {
+		t.mutex.Unlock()
+
+		return sequenceReordered
+	}
+
+	t.highest[key] = seq
+	t.mutex.Unlock()
+
+	if !seen || ahead == 1 {
+		return sequenceInOrder
+	}
+
+	if t.onGap != nil {
+		t.onGap(pkt.Src, (highest+1)&mask, seq)
+	}
+
+	return sequenceGap
+}
@@ -0,0 +1,262 @@
+package multicast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+)
+
+// socketPool shares one socket per interface and port between the consumers
+// of a listener. Each socket joins the groups of all consumers using it and
+// hands every datagram to the consumers of the group it was sent to.
+type socketPool struct {
+	mutex   sync.Mutex
+	sockets map[poolKey]*sharedSocket
+}
+
+type poolKey struct {
+	ifindex int
+	port    int
+}
+
+type sharedSocket struct {
+	socket
+
+	pool *socketPool
+	key  poolKey
+	ifi  *net.Interface
+
+	// The port the socket is bound to, which every datagram it reads was
+	// sent to
+	port int
+
+	// Routes datagrams to the consumers of the group they were sent to
+	dispatcher *Dispatcher
+
+	// Removes each consumer's handler, guarded by the pool's mutex
+	handles map[*Consumer]func()
+
+	// The largest read buffer size of the consumers that joined
+	readBuffer atomic.Int32
+}
+
+func newSocketPool() *socketPool {
+	return &socketPool{
+		sockets: make(map[poolKey]*sharedSocket),
+	}
+}
+
+// join adds the consumer to the socket of the interface and its port,
+// opening the socket if the consumer is the first one to use it.
+func (p *socketPool) join(c *Consumer, is *ifaceState) (*sharedSocket, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := poolKey{ifindex: is.ifi.Index, port: c.Address().Port}
+
+	s := p.sockets[key]
+	if s == nil {
+		var err error
+
+		s, err = p.open(c, is.ifi, key)
+		if err != nil {
+			return nil, err
+		}
+
+		p.sockets[key] = s
+
+		// Size the buffer before the first read
+		s.readBuffer.Store(c.readBuffer.Load())
+
+		go s.readLoop()
+	} else {
+		s.fitReadBuffer(c.readBuffer.Load())
+	}
+
+	if s.dispatcher.Handlers(c.Address()) == 0 {
+		if err := c.joinGroup(s.pc, is.ifi); err != nil {
+			s.closeIfUnused()
+			return nil, c.joinError(is.ifi, err)
+		}
+	}
+
+	s.handles[c] = s.dispatcher.Handle(c.Address(), func(pkt *Packet) {
+		c.receiveShared(is, pkt)
+
+		// Follow the consumer's buffer as it grows with truncated datagrams
+		s.fitReadBuffer(c.readBuffer.Load())
+	})
+
+	return s, nil
+}
+
+// fitReadBuffer raises the size of the socket's read buffer to at least
+// size bytes.
+func (s *sharedSocket) fitReadBuffer(size int32) {
+	for {
+		old := s.readBuffer.Load()
+		if old >= size || s.readBuffer.CompareAndSwap(old, size) {
+			return
+		}
+	}
+}
+
+func (p *socketPool) open(c *Consumer, ifi *net.Interface, key poolKey) (*sharedSocket, error) {
+	// Bind to the wildcard address to receive every group on the port
+	conn, err := c.openConn(ifi, net.IPv4zero)
+	if err != nil {
+		return nil, networkError(fmt.Errorf("failed to open shared multicast socket on interface %s: %w", ifi.Name, err))
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to get raw connection on interface %s: %w", ifi.Name, err)
+	}
+
+	s := &sharedSocket{
+		socket: socket{
+			conn: conn,
+			raw:  raw,
+			pc:   ipv4.NewPacketConn(conn),
+		},
+		pool:       p,
+		key:        key,
+		ifi:        ifi,
+		port:       key.port,
+		dispatcher: NewDispatcher(),
+		handles:    make(map[*Consumer]func()),
+	}
+
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		s.port = local.Port
+	}
+
+	if err := s.pc.SetControlMessage(ipv4.FlagDst, true); err != nil {
+		_ = s.pc.Close()
+		return nil, fmt.Errorf("failed to set control message on interface %s: %w", ifi.Name, err)
+	}
+
+	return s, nil
+}
+
+// leave removes the consumer from the socket, leaving its group once no
+// other consumer uses it, and closes the socket after its last consumer.
+func (s *sharedSocket) leave(c *Consumer) {
+	s.pool.mutex.Lock()
+	defer s.pool.mutex.Unlock()
+
+	if remove, ok := s.handles[c]; ok {
+		delete(s.handles, c)
+		remove()
+	}
+
+	if s.dispatcher.Handlers(c.Address()) == 0 {
+		_ = s.pc.LeaveGroup(s.ifi, c.Address())
+	}
+
+	s.closeIfUnused()
+}
+
+// closeIfUnused closes the socket once no group is joined on it anymore.
+// The pool's mutex must be held.
+func (s *sharedSocket) closeIfUnused() {
+	if s.dispatcher.Len() > 0 {
+		return
+	}
+
+	_ = s.pc.Close()
+
+	if s.pool.sockets[s.key] == s {
+		delete(s.pool.sockets, s.key)
+	}
+}
+
+func (s *sharedSocket) readLoop() {
+	buf := make([]byte, s.readBuffer.Load())
+	oob := make([]byte, oobSize)
+
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(
+		"multicast_port", fmt.Sprint(s.key.port),
+		"multicast_interface", s.ifi.Name,
+	)))
+
+	for {
+		if size := int(s.readBuffer.Load()); size > len(buf) {
+			buf = make([]byte, size)
+		}
+
+		n, oobn, flags, src, err := s.readMsg(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			continue
+		}
+
+		var cm ipv4.ControlMessage
+		if err := cm.Parse(oob[:oobn]); err != nil || cm.Dst == nil {
+			continue
+		}
+
+		truncated := flags&msgTrunc != 0
+
+		var truncatedBytes int
+		if n > len(buf) {
+			truncatedBytes = n - len(buf)
+			n = len(buf)
+		}
+
+		info := parseControl(oob[:oobn])
+
+		// Every consumer gets a copy of the payload it may keep
+		s.dispatcher.Dispatch(&Packet{
+			Src:       src,
+			Dst:       &net.UDPAddr{IP: cm.Dst, Port: s.port},
+			Payload:   buf[:n],
+			Truncated: truncated,
+			TOS:       info.tos,
+			HasTOS:    info.hasTOS,
+
+			truncatedBytes: truncatedBytes,
+		})
+	}
+}
+
+// receiveShared delivers a packet read from a shared socket. The socket
+// keeps reading for the other consumers, so packets arriving while delivery
+// is suspended are discarded rather than buffered.
+func (c *Consumer) receiveShared(is *ifaceState, pkt *Packet) {
+	c.mutex.Lock()
+	accepting := !c.closed && c.resume == nil
+	c.mutex.Unlock()
+
+	if !accepting || c.unexpectedSource(is, pkt.Src) {
+		return
+	}
+
+	if pkt.Truncated {
+		is.truncated.Add(1)
+		is.truncatedBytes.Add(uint64(pkt.truncatedBytes))
+
+		c.growReadBuffer(len(pkt.Payload)+pkt.truncatedBytes, len(pkt.Payload))
+	}
+
+	c.deliver(is, pkt)
+}
+
+// withSocketPool makes the consumer open its sockets through the pool.
+func withSocketPool(p *socketPool) ConsumerOption {
+	return func(o *consumerOptions) error {
+		o.pool = p
+
+		return nil
+	}
+}
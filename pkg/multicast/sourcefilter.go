@@ -0,0 +1,205 @@
+package multicast
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"golang.org/x/net/ipv4"
+)
+
+// SourceFilterMode selects which senders of the group a consumer receives,
+// following the IGMPv3 filter modes.
+type SourceFilterMode int
+
+const (
+	// SourceFilterAny receives every sender, as after a plain join.
+	SourceFilterAny SourceFilterMode = iota
+
+	// SourceFilterInclude only receives the listed senders.
+	SourceFilterInclude
+
+	// SourceFilterExclude receives every sender except the listed ones.
+	SourceFilterExclude
+)
+
+func (m SourceFilterMode) String() string {
+	switch m {
+	case SourceFilterAny:
+		return "any"
+	case SourceFilterInclude:
+		return "include"
+	case SourceFilterExclude:
+		return "exclude"
+	default:
+		return fmt.Sprintf("SourceFilterMode(%d)", int(m))
+	}
+}
+
+// sourceFilter is the filter the group is joined with.
+type sourceFilter struct {
+	mode    SourceFilterMode
+	sources []net.IP
+}
+
+var anySource = &sourceFilter{mode: SourceFilterAny}
+
+// SetSourceFilter changes the senders the consumer receives on all of its
+// interfaces, and on those started later. Sources are blocked and unblocked
+// on the joined group, so switching between SourceFilterAny and
+// SourceFilterExclude, or changing the sources of either SourceFilterInclude
+// or SourceFilterExclude, takes effect without a gap in traffic. Switching
+// to or from SourceFilterInclude leaves and rejoins the group. Only
+// supported on Linux, and not with sockets shared by a listener.
+func (c *Consumer) SetSourceFilter(mode SourceFilterMode, sources []net.IP) error {
+	filter, err := newSourceFilter(mode, sources)
+	if err != nil {
+		return err
+	}
+
+	if c.opts.pool != nil {
+		return errors.New("source filters are not supported with shared sockets")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	old := c.loadSourceFilter()
+
+	var undo []func()
+
+	for _, is := range c.ifaces {
+		for _, pc := range is.packetConns() {
+			undo = append(undo, func() {
+				_ = c.changeSourceFilter(pc, is.ifi, filter, old)
+			})
+
+			if err := c.changeSourceFilter(pc, is.ifi, old, filter); err != nil {
+				// Restore the old filter everywhere, including the partly
+				// changed socket, as the consumer keeps reporting it
+				for _, fn := range undo {
+					fn()
+				}
+
+				return fmt.Errorf("failed to set source filter on interface %s: %w", is.ifi.Name, err)
+			}
+		}
+	}
+
+	// Recorded only once every socket applies it
+	c.sourceFilter.Store(filter)
+
+	return nil
+}
+
+func newSourceFilter(mode SourceFilterMode, sources []net.IP) (*sourceFilter, error) {
+	switch mode {
+	case SourceFilterAny:
+		if len(sources) > 0 {
+			return nil, errors.New("sources given for any-source filter")
+		}
+
+	case SourceFilterInclude:
+		if len(sources) == 0 {
+			return nil, errors.New("include filter requires at least one source")
+		}
+
+	case SourceFilterExclude:
+
+	default:
+		return nil, fmt.Errorf("invalid source filter mode %d", int(mode))
+	}
+
+	filter := &sourceFilter{mode: mode}
+
+	for _, src := range sources {
+		ip := src.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("source %s is not an IPv4 address", src)
+		}
+
+		if !filter.has(ip) {
+			filter.sources = append(filter.sources, ip)
+		}
+	}
+
+	return filter, nil
+}
+
+func (f *sourceFilter) has(ip net.IP) bool {
+	return slices.ContainsFunc(f.sources, ip.Equal)
+}
+
+// loadSourceFilter returns the consumer's source filter.
+func (c *Consumer) loadSourceFilter() *sourceFilter {
+	if filter := c.sourceFilter.Load(); filter != nil {
+		return filter
+	}
+
+	return anySource
+}
+
+// joinFiltered joins the group on pc with the given source filter.
+func (c *Consumer) joinFiltered(pc *ipv4.PacketConn, ifi *net.Interface, filter *sourceFilter) error {
+	if filter.mode == SourceFilterInclude {
+		for _, src := range filter.sources {
+			if err := pc.JoinSourceSpecificGroup(ifi, c.Address(), &net.IPAddr{IP: src}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := pc.JoinGroup(ifi, c.Address()); err != nil {
+		return err
+	}
+
+	for _, src := range filter.sources {
+		if err := pc.ExcludeSourceSpecificGroup(ifi, c.Address(), &net.IPAddr{IP: src}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// changeSourceFilter moves the membership on pc from the old to the new
+// filter. Added sources are joined or blocked before removed ones are left
+// or unblocked, so an include filter never drops to no sources on the way.
+func (c *Consumer) changeSourceFilter(pc *ipv4.PacketConn, ifi *net.Interface, old, filter *sourceFilter) error {
+	if (old.mode == SourceFilterInclude) != (filter.mode == SourceFilterInclude) {
+		// Leaving fails if the kernel already dropped the membership
+		_ = pc.LeaveGroup(ifi, c.Address())
+
+		return c.joinFiltered(pc, ifi, filter)
+	}
+
+	add, remove := pc.ExcludeSourceSpecificGroup, pc.IncludeSourceSpecificGroup
+	if filter.mode == SourceFilterInclude {
+		add, remove = pc.JoinSourceSpecificGroup, pc.LeaveSourceSpecificGroup
+	}
+
+	for _, src := range filter.sources {
+		if !old.has(src) {
+			if err := add(ifi, c.Address(), &net.IPAddr{IP: src}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, src := range old.sources {
+		if !filter.has(src) {
+			if err := remove(ifi, c.Address(), &net.IPAddr{IP: src}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
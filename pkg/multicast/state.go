@@ -0,0 +1,54 @@
+package multicast
+
+// State is the lifecycle state of a consumer.
+type State int
+
+const (
+	// StateRunning is the state of a consumer receiving packets.
+	StateRunning State = iota
+
+	// StateClosing is the state of a consumer that has been closed with
+	// WithDrainOnClose and is still delivering the packets it queued.
+	StateClosing
+
+	// StateClosed is the state of a consumer that has been closed and
+	// delivers no more packets.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateClosing:
+		return "closing"
+	default:
+		return "closed"
+	}
+}
+
+// State returns the consumer's lifecycle state.
+func (c *Consumer) State() State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case !c.closed:
+		return StateRunning
+	case c.draining:
+		return StateClosing
+	default:
+		return StateClosed
+	}
+}
+
+// StopReason returns why the consumer stopped, or nil while it is running.
+// This is net.ErrClosed after Close, and the error passed to CloseWithError
+// otherwise, which lets a supervisor tell an intentional close from a
+// failure it should recover from by creating a new consumer.
+func (c *Consumer) StopReason() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.stopReason
+}
@@ -0,0 +1,199 @@
+package multicast
+
+import (
+	"net"
+	"time"
+)
+
+type Stats struct {
+	// Packets and Bytes count the packets passed on to the callback, not
+	// those dropped as Invalid or Duplicates.
+	Packets uint64
+	Bytes   uint64
+
+	// KernelDrops is the number of datagrams the kernel dropped before they
+	// could be read, for example because the socket receive buffer was full.
+	// Only available on Linux.
+	KernelDrops uint64
+
+	// Truncated is the number of datagrams that didn't fit into the read
+	// buffer and were cut short.
+	Truncated uint64
+
+	// TruncatedBytes is the number of bytes lost to truncation. Only
+	// available on Linux, where the real datagram size is known.
+	TruncatedBytes uint64
+
+	// Rejoins is the number of times the group was left and joined again
+	Rejoins uint64
+
+	// CongestionExperienced is the number of packets carrying the ECN CE
+	// mark. Only counted with the WithECN option.
+	CongestionExperienced uint64
+
+	// QueueDrops is the number of packets dropped because the delivery queue
+	// exceeded its byte limit.
+	QueueDrops uint64
+
+	// SequenceGaps is the number of times the sequence numbers of the
+	// packets skipped ahead, which means packets were lost. Only counted
+	// with the WithSequenceGaps option.
+	SequenceGaps uint64
+
+	// Reordered is the number of packets whose sequence number was at or
+	// below the highest one seen, because they arrived late or twice. Only
+	// counted with the WithSequenceGaps option.
+	Reordered uint64
+
+	// DestinationMismatches is the number of datagrams dropped because they
+	// were sent to another destination than the group, which happens when
+	// the sockets are bound to 0.0.0.0 or with WithMulticastAll. Each one is
+	// logged at debug level with its actual destination.
+	DestinationMismatches uint64
+
+	// Duplicates is the number of packets dropped because another feed
+	// already delivered their sequence number. Only counted with the
+	// WithRedundancy option.
+	Duplicates uint64
+
+	// SourcePortMismatches is the number of datagrams dropped because they
+	// weren't sent from the port set with WithExpectedSourcePort.
+	SourcePortMismatches uint64
+
+	// Invalid is the number of packets dropped because their payload failed
+	// the validation set with WithValidator.
+	Invalid uint64
+}
+
+type IfaceStat struct {
+	Interface *net.Interface
+	Stats
+
+	// Consumer is the name of the consumer, see WithName, to label metrics
+	// with
+	Consumer string
+
+	// Joined reports whether the group is currently joined on the interface
+	Joined bool
+
+	// LastError is the most recent join or read error seen on the interface
+	LastError error
+
+	// LastPacket is the time the last packet was received, or the zero time
+	LastPacket time.Time
+
+	// SuspectedPruning is set once traffic resumed right after a rejoin by
+	// WithRejoinOnSilence. This suggests that a switch stopped forwarding
+	// the group, typically because there is no IGMP querier on the segment,
+	// rather than that the sender went quiet.
+	SuspectedPruning bool
+}
+
+type ListenerStats struct {
+	Stats
+
+	// Consumers is the number of consumers that have not been closed
+	Consumers int
+
+	// Interfaces is the number of interfaces the listener was created with
+	Interfaces int
+}
+
+func (s *Stats) add(o Stats) {
+	s.Packets += o.Packets
+	s.Bytes += o.Bytes
+	s.KernelDrops += o.KernelDrops
+	s.Truncated += o.Truncated
+	s.TruncatedBytes += o.TruncatedBytes
+	s.Rejoins += o.Rejoins
+	s.CongestionExperienced += o.CongestionExperienced
+	s.QueueDrops += o.QueueDrops
+	s.SequenceGaps += o.SequenceGaps
+	s.Reordered += o.Reordered
+	s.DestinationMismatches += o.DestinationMismatches
+	s.Duplicates += o.Duplicates
+	s.SourcePortMismatches += o.SourcePortMismatches
+	s.Invalid += o.Invalid
+}
+
+func (is *ifaceState) stats() Stats {
+	stats := Stats{
+		Packets:     is.packets.Load(),
+		Bytes:       is.bytes.Load(),
+		KernelDrops: is.kernelDrops.Load(),
+
+		Truncated:      is.truncated.Load(),
+		TruncatedBytes: is.truncatedBytes.Load(),
+		Rejoins:        is.rejoins.Load(),
+
+		CongestionExperienced: is.congestionExperienced.Load(),
+		QueueDrops:            is.queueDrops.Load(),
+		SequenceGaps:          is.sequenceGaps.Load(),
+		Reordered:             is.reordered.Load(),
+		DestinationMismatches: is.dstMismatches.Load(),
+		Duplicates:            is.duplicates.Load(),
+		SourcePortMismatches:  is.sourcePortMismatches.Load(),
+		Invalid:               is.invalid.Load(),
+	}
+
+	for _, s := range is.sockets {
+		stats.KernelDrops += s.kernelDrops.Load()
+	}
+
+	return stats
+}
+
+func (is *ifaceState) snapshot() IfaceStat {
+	stat := IfaceStat{
+		Interface: is.ifi,
+		Stats:     is.stats(),
+		Joined:    is.joined.Load(),
+		LastError: is.lastError(),
+
+		SuspectedPruning: is.suspectedPruning.Load(),
+	}
+
+	if ns := is.lastPacket.Load(); ns != 0 {
+		stat.LastPacket = time.Unix(0, ns)
+	}
+
+	return stat
+}
+
+func (is *ifaceState) setError(err error) {
+	is.errMutex.Lock()
+	is.lastErr = err
+	is.errMutex.Unlock()
+}
+
+func (is *ifaceState) lastError() error {
+	is.errMutex.Lock()
+	defer is.errMutex.Unlock()
+
+	return is.lastErr
+}
+
+// statsLogger logs the consumer's statistics every configured interval until
+// it is closed.
+func (c *Consumer) statsLogger() {
+	ticker := c.opts.clock.NewTicker(c.opts.statsLogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+
+		case <-ticker.C():
+			stats := c.Stats()
+
+			c.opts.logger.Info("multicast consumer stats",
+				"group", c.Address().String(),
+				"packets", stats.Packets,
+				"bytes", stats.Bytes,
+				"kernel_drops", stats.KernelDrops,
+				"queue_drops", stats.QueueDrops,
+				"truncated", stats.Truncated)
+		}
+	}
+}
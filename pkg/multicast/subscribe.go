@@ -0,0 +1,47 @@
+package multicast
+
+import (
+	"net"
+	"slices"
+	"sync"
+)
+
+// Subscribe joins the group addr on ifis and returns a channel of the
+// received packets, along with a function that stops the subscription and
+// closes the channel. Reading pauses while the channel is full, leaving
+// further datagrams to the kernel's socket buffers. The options are those of
+// NewConsumer, except for the callbacks.
+func Subscribe(addr *net.UDPAddr, ifis []*net.Interface, opts ...ConsumerOption) (<-chan Packet, func(), error) {
+	packets := make(chan Packet, queueSize)
+	done := make(chan struct{})
+
+	cb := func(pkt *Packet) {
+		pkt.Retain()
+
+		select {
+		case packets <- *pkt:
+		case <-done:
+		}
+	}
+
+	consumer, err := NewConsumer(addr, ifis, nil, append(slices.Clip(opts), WithPacketCallback(cb))...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			consumer.Close()
+
+			// Nothing may send on the channel anymore once it is closed
+			consumer.readers.Wait()
+			consumer.workers.Wait()
+			close(packets)
+		})
+	}
+
+	return packets, cancel, nil
+}
@@ -0,0 +1,50 @@
+package multicast
+
+// SuspendDelivery stops the read loops while keeping the group membership,
+// so that incoming traffic queues up in the kernel's socket buffers instead
+// of reaching the callback. Datagrams that don't fit are dropped by the
+// kernel and reported in Stats.KernelDrops. Calls to Inject block until
+// delivery resumes.
+func (c *Consumer) SuspendDelivery() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+}
+
+// ResumeDelivery restarts delivery after SuspendDelivery, starting with the
+// datagrams buffered in the meantime.
+func (c *Consumer) ResumeDelivery() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+
+		if c.opts.mux != nil {
+			c.opts.mux.unpark(c)
+		}
+	}
+}
+
+// waitDelivery blocks while delivery is suspended. It returns false if the
+// consumer was closed in the meantime.
+func (c *Consumer) waitDelivery() bool {
+	c.mutex.Lock()
+	resume := c.resume
+	c.mutex.Unlock()
+
+	if resume == nil {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-c.done:
+		return false
+	}
+}
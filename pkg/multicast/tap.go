@@ -0,0 +1,135 @@
+package multicast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tap records are framed as follows, all integers in network byte order:
+//
+//	8 bytes  receive time, Unix nanoseconds
+//	4 bytes  interface index
+//	4 bytes  source IPv4 address
+//	2 bytes  source port
+//	4 bytes  destination IPv4 address
+//	2 bytes  destination port
+//	4 bytes  payload length
+//	n bytes  payload
+const (
+	tapHeaderLen = 28
+)
+
+type TapRecord struct {
+	Time           time.Time
+	InterfaceIndex int
+	Src            *net.UDPAddr
+	Dst            *net.UDPAddr
+	Payload        []byte
+}
+
+// tap writes a record of every packet to w, framed by encode.
+type tap struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	encode func(pkt *Packet, ts time.Time) []byte
+
+	// A file header not written yet, written by start or ahead of the
+	// first record
+	header []byte
+}
+
+// start writes the file header, once the consumer has started.
+func (t *tap) start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.writeHeader()
+}
+
+// writeHeader writes the pending file header, if any. The mutex must be
+// held.
+func (t *tap) writeHeader() error {
+	if t.header == nil {
+		return nil
+	}
+
+	header := t.header
+	t.header = nil
+
+	if _, err := t.w.Write(header); err != nil {
+		return fmt.Errorf("failed to write tap header: %w", err)
+	}
+
+	return nil
+}
+
+// write records pkt as received at its Timestamp, or at now if it has none.
+func (t *tap) write(pkt *Packet, now time.Time) {
+	ts := pkt.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+
+	record := t.encode(pkt, ts)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// A failing tap must not affect delivery
+	_ = t.writeHeader()
+	_, _ = t.w.Write(record)
+}
+
+// encodeTapRecord frames a packet received at ts as a tap record.
+func encodeTapRecord(pkt *Packet, ts time.Time) []byte {
+	record := make([]byte, tapHeaderLen+len(pkt.Payload))
+
+	binary.BigEndian.PutUint64(record[0:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(record[8:12], uint32(pkt.Interface.Index))
+
+	if udpSrc, ok := pkt.Src.(*net.UDPAddr); ok {
+		copy(record[12:16], udpSrc.IP.To4())
+		binary.BigEndian.PutUint16(record[16:18], uint16(udpSrc.Port))
+	}
+
+	copy(record[18:22], pkt.Dst.IP.To4())
+	binary.BigEndian.PutUint16(record[22:24], uint16(pkt.Dst.Port))
+	binary.BigEndian.PutUint32(record[24:28], uint32(len(pkt.Payload)))
+	copy(record[tapHeaderLen:], pkt.Payload)
+
+	return record
+}
+
+// ReadTapRecord reads the next record written by a consumer created with
+// WithTap. It returns io.EOF when r is exhausted.
+func ReadTapRecord(r io.Reader) (*TapRecord, error) {
+	header := make([]byte, tapHeaderLen)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	record := &TapRecord{
+		Time:           time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		InterfaceIndex: int(binary.BigEndian.Uint32(header[8:12])),
+		Src: &net.UDPAddr{
+			IP:   net.IPv4(header[12], header[13], header[14], header[15]),
+			Port: int(binary.BigEndian.Uint16(header[16:18])),
+		},
+		Dst: &net.UDPAddr{
+			IP:   net.IPv4(header[18], header[19], header[20], header[21]),
+			Port: int(binary.BigEndian.Uint16(header[22:24])),
+		},
+		Payload: make([]byte, binary.BigEndian.Uint32(header[24:28])),
+	}
+
+	if _, err := io.ReadFull(r, record.Payload); err != nil {
+		return nil, fmt.Errorf("failed to read tap record payload: %w", io.ErrUnexpectedEOF)
+	}
+
+	return record, nil
+}
@@ -0,0 +1,35 @@
+package multicast
+
+// TimestampSource tells where a packet's receive timestamp was taken, and
+// with it how accurate the timestamp is.
+type TimestampSource int
+
+const (
+	// TimestampNone is the source of packets without a timestamp.
+	TimestampNone TimestampSource = iota
+
+	// TimestampHardware is a timestamp taken by the NIC.
+	TimestampHardware
+
+	// TimestampKernel is a timestamp taken by the kernel when the datagram
+	// arrived.
+	TimestampKernel
+
+	// TimestampUserspace is a timestamp taken right after the datagram was
+	// read, on platforms where the kernel doesn't provide one. It includes
+	// the time the datagram waited in the socket's receive buffer.
+	TimestampUserspace
+)
+
+func (s TimestampSource) String() string {
+	switch s {
+	case TimestampNone:
+		return "none"
+	case TimestampHardware:
+		return "hardware"
+	case TimestampKernel:
+		return "kernel"
+	default:
+		return "userspace"
+	}
+}
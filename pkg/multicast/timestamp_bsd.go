@@ -0,0 +1,54 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package multicast
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+)
+
+// enableTimestamps requests kernel receive timestamps (SO_TIMESTAMP).
+func enableTimestamps(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
+
+func parseControl(oob []byte) controlInfo {
+	var info controlInfo
+
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return info
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_SOCKET || msg.Header.Type != syscall.SCM_TIMESTAMP {
+			continue
+		}
+
+		var tv syscall.Timeval
+		if _, err := binary.Decode(msg.Data, binary.NativeEndian, &tv); err != nil {
+			continue
+		}
+
+		info.timestamp = time.Unix(0, tv.Nano())
+		info.timestampSource = TimestampKernel
+	}
+
+	return info
+}
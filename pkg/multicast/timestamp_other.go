@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package multicast
+
+import (
+	"net"
+)
+
+// enableTimestamps does nothing, packets are timestamped in userspace.
+func enableTimestamps(_ *net.UDPConn) error {
+	return nil
+}
+
+func parseControl(_ []byte) controlInfo {
+	return controlInfo{}
+}
@@ -0,0 +1,28 @@
+package multicast
+
+// watchCallback wraps cb to warn about invocations that take longer than the
+// configured deadline. The callback is never interrupted.
+func (c *Consumer) watchCallback(cb PacketCallback) PacketCallback {
+	deadline := c.opts.callbackDeadline
+	clock := c.opts.clock
+
+	return func(pkt *Packet) {
+		start := clock.Now()
+
+		timer := clock.AfterFunc(deadline, func() {
+			c.opts.logger.Warn("multicast callback exceeded deadline",
+				"group", c.Address().String(),
+				"interface", pkt.Interface.Name,
+				"deadline", deadline)
+		})
+
+		cb(pkt)
+
+		if !timer.Stop() {
+			c.opts.logger.Warn("multicast callback returned after exceeding deadline",
+				"group", c.Address().String(),
+				"interface", pkt.Interface.Name,
+				"duration", clock.Now().Sub(start))
+		}
+	}
+}